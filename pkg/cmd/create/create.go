@@ -3,15 +3,22 @@ package create
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/jobwatch"
+	"github.com/jenkins-x-plugins/jx-test/pkg/locks"
+	"github.com/jenkins-x-plugins/jx-test/pkg/policy"
+	"github.com/jenkins-x-plugins/jx-test/pkg/redact"
+	"github.com/jenkins-x-plugins/jx-test/pkg/report"
+	"github.com/jenkins-x-plugins/jx-test/pkg/schema"
 	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
 	"k8s.io/client-go/kubernetes"
 
-	"github.com/Masterminds/sprig/v3"
 	"github.com/jenkins-x-plugins/jx-test/pkg/root"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cmdrunner"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/helper"
@@ -20,17 +27,16 @@ import (
 	"github.com/jenkins-x/jx-helpers/v3/pkg/kube"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/pipelinectx"
-	"github.com/jenkins-x/jx-helpers/v3/pkg/templater"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
 	"github.com/jenkins-x/jx-logging/v3/pkg/log"
 
 	"github.com/spf13/cobra"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
-	"sigs.k8s.io/yaml"
 )
 
 var (
@@ -42,7 +48,27 @@ var (
 
 	cmdExample = templates.Examples(`
 		%s create --test-url https://github.com/myorg/mytest.git
+
+		# lint the templates in a Pull Request check without connecting to a cluster
+		%s create -f tf.yaml --render-only --schema terraform-crd.yaml
 	`)
+
+	// defaultStaticMappings the resources used if they cannot be found via discovery
+	defaultStaticMappings = []dynkube.StaticMapping{
+		{
+			GroupVersionKind: terraforms.V1Alpha1.KindVersion(),
+			Resource:         terraforms.V1Alpha1.Resource.Resource,
+		},
+		{
+			GroupVersionKind: terraforms.V1Beta1.KindVersion(),
+			Resource:         terraforms.V1Beta1.Resource.Resource,
+		},
+	}
+)
+
+const (
+	// DefaultFieldManager the default field manager used for server-side apply
+	DefaultFieldManager = "jx-test"
 )
 
 // Options the options for the command
@@ -56,11 +82,36 @@ type Options struct {
 	NoDeleteResource bool
 	LogResource      bool
 	VerifyResult     bool
+	JobTimeout       time.Duration
+	JobPollPeriod    time.Duration
+	JobResult        *jobwatch.Result
+	JSONReport       string
+	JUnitReport      string
+	Apply            bool
+	FieldManager     string
+	RenderOnly       bool
+	NoValidateSchema bool
+	NoLock           bool
+	LockTimeout      time.Duration
+	LockDuration     time.Duration
+	LockPollPeriod   time.Duration
+	Lock             *locks.Lock
+	SchemaFiles      []string
+	Report           *report.Report
+	Validator        *schema.Validator
+	Out              io.Writer
 	Env              map[string]string
 	EnvVars          []string
+	SecretEnvPattern string
+	SecretEnvVars    []string
+	SecretEnv        map[string]string
+	SecretName       string
+	RedactPatterns   []string
+	Redactor         *redact.Redactor
 	KubeClient       kubernetes.Interface
 	DynamicClient    dynamic.Interface
 	Ctx              context.Context
+	RESTMapper       meta.RESTMapper
 	Client           dynamic.ResourceInterface
 	CommandRunner    cmdrunner.CommandRunner
 }
@@ -73,7 +124,7 @@ func NewCmdCreate() (*cobra.Command, *Options) {
 		Use:     "create",
 		Short:   "Create a new TestRun resource to record the test case resources",
 		Long:    cmdLong,
-		Example: fmt.Sprintf(cmdExample, root.BinaryName),
+		Example: fmt.Sprintf(cmdExample, root.BinaryName, root.BinaryName),
 		Run: func(_ *cobra.Command, _ []string) {
 			err := o.Run()
 			helper.CheckErr(err)
@@ -93,18 +144,47 @@ func NewCmdCreate() (*cobra.Command, *Options) {
 
 	o.Options.AddFlags(cmd)
 
-	cmd.Flags().StringVarP(&o.File, "file", "f", "", "the template file to create")
+	cmd.Flags().StringVarP(&o.File, "file", "f", "", "the template file or directory of template files to create. Templates can contain multiple YAML documents")
 	cmd.Flags().StringVarP(&o.EnvPattern, "env-pattern", "", "TF_.*", "the regular expression for environment variables to automatically include")
 	cmd.Flags().StringArrayVarP(&o.EnvVars, "env", "e", nil, "specifies env vars of the form name=value")
+	cmd.Flags().StringVarP(&o.SecretEnvPattern, "secret-env-pattern", "", "", "the regular expression for the names of sensitive environment variables which are stored in a Secret owned by the test resource rather than passed to the templates")
+	cmd.Flags().StringArrayVarP(&o.SecretEnvVars, "secret-env", "", nil, "specifies sensitive env vars of the form name=value, or the name of an env var, which are stored in a Secret owned by the test resource rather than passed to the templates")
+	cmd.Flags().StringArrayVarP(&o.RedactPatterns, "redact-pattern", "", redact.DefaultPatterns, "the case insensitive glob patterns of the names of env vars whose values are masked in the logged templates, rendered output and reports")
 	cmd.Flags().BoolVarP(&o.NoWatchJob, "no-watch-job", "", false, "disables watching of the job created by the resource")
 	cmd.Flags().BoolVarP(&o.NoDeleteResource, "no-delete", "", false, "disables deleting of the test resource after the job has completed successfully")
 	cmd.Flags().BoolVarP(&o.LogResource, "log", "", true, "logs the generated resource before applying it")
 	cmd.Flags().BoolVarP(&o.VerifyResult, "verify-result", "", false, "verifies the output of the boot job to ensure it succeeded")
+	cmd.Flags().DurationVarP(&o.JobTimeout, "job-timeout", "", time.Hour, "the maximum amount of time to wait for the job created by the resource to complete")
+	cmd.Flags().BoolVarP(&o.NoLock, "no-lock", "", false, "disables the lock which stops builds of the same Pull Request and context from replacing each other's resources concurrently")
+	cmd.Flags().DurationVarP(&o.LockTimeout, "lock-timeout", "", locks.DefaultTimeout, "the maximum amount of time to wait for the lock held by another run of the same build. Locks held by older builds are pre-empted")
+	cmd.Flags().DurationVarP(&o.LockDuration, "lock-duration", "", locks.DefaultDuration, "how long the lock is held before it expires if it is not released, for example if the build is cancelled")
+	cmd.Flags().StringVarP(&o.JSONReport, "json-report", "", "", "the file to write a JSON summary of the test run to")
+	cmd.Flags().StringVarP(&o.JUnitReport, "junit-report", "", "", "the file to write a JUnit XML report of the test run to")
+	cmd.Flags().BoolVarP(&o.Apply, "apply", "", false, "uses server-side apply to update the test resources of the Pull Request and context in place rather than deleting and recreating them for each build. The resources are kept after the Job succeeds so that later builds can reuse them")
+	cmd.Flags().StringVarP(&o.FieldManager, "field-manager", "", DefaultFieldManager, "the field manager used for server-side apply if --apply is enabled")
+	cmd.Flags().BoolVarP(&o.RenderOnly, "render-only", "", false, "evaluates and validates the templates and prints the generated YAML without connecting to a cluster")
+	cmd.Flags().StringArrayVarP(&o.SchemaFiles, "schema", "", nil, "the CustomResourceDefinition YAML files whose OpenAPI schemas the generated resources are validated against. Otherwise the CustomResourceDefinitions are loaded from the cluster")
+	cmd.Flags().BoolVarP(&o.NoValidateSchema, "no-validate-schema", "", false, "disables validating the generated resources against the OpenAPI schemas of their CustomResourceDefinitions")
 	return cmd, o
 }
 
 // Run implements the command
 func (o *Options) Run() error {
+	o.Report = report.New(time.Now())
+	err := o.run()
+	o.Report.Finish(err, time.Now())
+
+	reportErr := o.writeReports()
+	if err != nil {
+		if reportErr != nil {
+			log.Logger().Warnf("%s", reportErr.Error())
+		}
+		return err
+	}
+	return reportErr
+}
+
+func (o *Options) run() error {
 	err := o.Validate()
 	if err != nil {
 		return fmt.Errorf("failed to validate: %w", err)
@@ -113,145 +193,471 @@ func (o *Options) Run() error {
 	log.Logger().Infof("resource: %s", info(o.ResourceName))
 	log.Logger().Infof("labels: %v", o.Labels)
 
-	templateText, err := os.ReadFile(o.File)
+	o.Name = o.ResourceName
+	o.Report.Name = o.Name
+	o.Report.Labels = o.Labels
+
+	phase := o.Report.StartPhase(report.PhaseRender)
+	resources, err := o.LoadResources()
+	if err == nil {
+		err = o.validateResources(o.GetContext(), resources)
+	}
+	phase.End(err)
 	if err != nil {
-		return fmt.Errorf("failed to read %s: %w", o.File, err)
+		return fmt.Errorf("failed to load resources: %w", err)
 	}
+	if o.RenderOnly {
+		return o.printResources(resources)
+	}
+	primary := resources[0]
+	kind := primary.Kind()
+	ns := primary.Namespace()
+	o.Report.Namespace = ns
 
-	o.Name = o.ResourceName
-	funcMap := sprig.TxtFuncMap()
+	o.Client = primary.Client(o.DynamicClient)
+	ctx := o.GetContext()
 
-	output, err := templater.Evaluate(funcMap, o, string(templateText), o.File, "resource template")
+	// lets stop other builds of this Pull Request and Context replacing the resources at the same time
+	err = o.acquireLock(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to evaluate template %s: %w", o.File, err)
+		return err
 	}
+	defer o.releaseLock(ctx)
 
-	if o.LogResource {
-		log.Logger().Infof("generated template: %s", output)
+	// lets stop removing and creating resources as soon as a newer build pre-empts the lock
+	lockCtx, cancel := o.lockContext(ctx)
+	defer cancel()
+
+	// lets delete all the previous resources for this Pull Request and Context
+	phase = o.Report.StartPhase(report.PhaseCleanup)
+	err = o.deletePreviousResources(lockCtx, resources)
+	phase.End(err)
+	if err != nil {
+		lockErr := o.checkLock(ctx, nil)
+		if lockErr != nil {
+			return lockErr
+		}
+		return fmt.Errorf("failed to delete previous resources: %w", err)
 	}
 
-	u := &unstructured.Unstructured{}
-	err = yaml.Unmarshal([]byte(output), u)
+	// now lets create the new resources with the primary resource last so that any resources it uses exist
+	name := o.Name
+	if name == "" {
+		return fmt.Errorf("no name defaulted")
+	}
+	previous, err := o.findPrevious(ctx, primary)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal the template of file %s has YAML: %s: %w", o.File, output, err)
+		return err
 	}
 
-	// modify labels
-	labels := u.GetLabels()
-	if labels == nil {
-		labels = map[string]string{}
+	err = o.checkLock(ctx, nil)
+	if err != nil {
+		return err
 	}
-	if o.Labels["kind"] == "" {
-		o.Labels["kind"] = terraforms.LabelValueKindTest
+	phase = o.Report.StartPhase(report.PhaseCreate)
+	err = o.createResources(lockCtx, resources)
+	phase.End(err)
+	if err != nil {
+		lockErr := o.checkLock(ctx, resources)
+		if lockErr != nil {
+			return lockErr
+		}
+		return err
 	}
-	for k, v := range o.Labels {
-		labels[k] = v
+	err = o.checkLock(ctx, resources)
+	if err != nil {
+		return err
 	}
-	u.SetLabels(labels)
+	o.releaseLock(ctx)
 
-	// modify name
-	u.SetName(o.ResourceName)
-	ns := o.Namespace
-	if ns != "" {
-		u.SetNamespace(ns)
+	if previous != nil && primary.Object.GetGeneration() == previous.Generation {
+		reason := fmt.Sprintf("the spec of %s %s is unchanged so no new Job is created", kind, name)
+		log.Logger().Infof("%s", reason)
+		o.Report.Skip(report.PhaseJob, reason)
+		o.Report.Skip(report.PhaseDelete, reason)
+		return nil
+	}
+	if o.NoWatchJob {
+		o.Report.Skip(report.PhaseJob, "--no-watch-job is enabled")
+		o.Report.Skip(report.PhaseDelete, "--no-watch-job is enabled")
+		return nil
+	}
+	phase = o.Report.StartPhase(report.PhaseJob)
+	o.JobResult, err = o.watchJob(ctx, primary, previous)
+	if err != nil {
+		phase.End(err)
+		return fmt.Errorf("failed to watch job: %w", err)
+	}
+	o.Report.JobOutcome = string(o.JobResult.Outcome)
+	err = o.JobResult.Err()
+	phase.End(err)
+	if err != nil {
+		return fmt.Errorf("job failed to complete successfully: %w", err)
 	}
 
-	kind := u.GetKind()
-	apiVersion := u.GetAPIVersion()
-	if kind == "" {
-		return fmt.Errorf("generated template of file %s has missing kind", o.File)
+	if o.NoDeleteResource {
+		o.Report.Skip(report.PhaseDelete, "--no-delete is enabled")
+		return nil
 	}
-	if apiVersion == "" {
-		return fmt.Errorf("generated template of file %s has missing apiVersion", o.File)
+	if o.Apply {
+		log.Logger().Infof("not removing the test %s %s in namespace %s so that later builds can reuse it", kind, info(name), info(ns))
+		o.Report.Skip(report.PhaseDelete, "--apply is enabled so the resources are reused by later builds")
+		return nil
 	}
 
-	gv, err := schema.ParseGroupVersion(apiVersion)
+	tf, err := o.Client.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to parse apiVersion: %s: %w", apiVersion, err)
+		return fmt.Errorf("failed to find %s %s in namespace %s: %w", kind, name, ns, err)
 	}
-	resourceName := strings.ToLower(kind) + "s"
-	gvr := schema.GroupVersionResource{Group: gv.Group, Version: gv.Version, Resource: resourceName}
 
-	o.Client = dynkube.DynamicResource(o.DynamicClient, ns, gvr)
-	ctx := o.GetContext()
-	selector := dynkube.ToSelector(o.Labels)
-
-	// lets delete all the previous resources for this Pull Request and Context
-	list, err := dynkube.DynamicResource(o.DynamicClient, ns, gvr).List(ctx, metav1.ListOptions{
-		LabelSelector: selector,
-	})
-	if err != nil && apierrors.IsNotFound(err) {
-		return fmt.Errorf("could not find resources for : %w", err)
+	decision := policy.Evaluate(tf, time.Now())
+	if decision.Keep {
+		log.Logger().Infof("not removing the test %s %s in namespace %s: %s", kind, info(name), info(ns), decision.Reason)
+		o.Report.Skip(report.PhaseDelete, decision.Reason)
+		return nil
 	}
-	if list != nil {
-		for _, r := range list.Items {
-			name := r.GetName()
 
-			err = terraforms.DeleteActiveTerraformJobs(ctx, o.KubeClient, ns, name)
-			if err != nil {
-				return fmt.Errorf("failed to delete active Terraform Jobs for namespace %s name %s: %w", ns, name, err)
-			}
+	phase = o.Report.StartPhase(report.PhaseDelete)
+	err = o.deleteResources(ctx, resources, "Job succeeded")
+	phase.End(err)
+	return err
+}
 
-			err = dynkube.DynamicResource(o.DynamicClient, ns, gvr).Delete(ctx, name, metav1.DeleteOptions{})
+// validateResources validates the resources against the schemas of their kinds loading the
+// CustomResourceDefinitions from the cluster for any kinds without a schema file
+func (o *Options) validateResources(ctx context.Context, resources []*Resource) error {
+	if o.NoValidateSchema {
+		return nil
+	}
+	for _, r := range resources {
+		if !o.RenderOnly && !o.Validator.HasSchema(r.Object.GroupVersionKind()) {
+			_, err := o.Validator.LoadCRD(ctx, o.DynamicClient, r.Resource.GroupResource())
 			if err != nil {
-				return fmt.Errorf("failed to delete %s: %w", name, err)
+				return fmt.Errorf("failed to load the schema of %s %s: %w", r.Kind(), r.Name(), err)
 			}
-			log.Logger().Infof("deleted previous pipeline %s %s", kind, info(name))
+		}
+		err := o.Validator.Validate(r.Object)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// now lets create the new resource
-	name := o.Name
-	if name == "" {
-		return fmt.Errorf("no name defaulted")
+// printResources prints the generated resources as a multi document YAML with any sensitive values masked
+func (o *Options) printResources(resources []*Resource) error {
+	objects := make([]*unstructured.Unstructured, 0, len(resources))
+	for _, r := range resources {
+		objects = append(objects, r.Object)
 	}
-
-	_, err = dynkube.DynamicResource(o.DynamicClient, ns, gvr).Get(ctx, name, metav1.GetOptions{})
-	if err == nil {
-		return fmt.Errorf("should not have a %s called %s", kind, name)
+	text, err := o.redactedYAML(objects)
+	if err != nil {
+		return err
 	}
-	if err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to check if %s %s exists: %w", kind, name, err)
+	_, err = fmt.Fprint(o.Out, text)
+	if err != nil {
+		return fmt.Errorf("failed to write resources: %w", err)
 	}
+	return nil
+}
 
-	_, err = dynkube.DynamicResource(o.DynamicClient, ns, gvr).Create(ctx, u, metav1.CreateOptions{})
+// acquireLock acquires the lock on the test resources of the Pull Request and Context unless it is disabled
+func (o *Options) acquireLock(ctx context.Context) error {
+	if o.NoLock {
+		return nil
+	}
+	o.Lock = &locks.Lock{
+		KubeClient: o.KubeClient,
+		Namespace:  o.Namespace,
+		Labels:     o.Labels,
+		Build:      o.BuildNumber,
+		Duration:   o.LockDuration,
+		Timeout:    o.LockTimeout,
+		PollPeriod: o.LockPollPeriod,
+	}
+	err := o.Lock.Acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create %s %s: %w", kind, name, err)
+		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
-	log.Logger().Infof("created %s %s", kind, info(name))
+	return nil
+}
 
-	if o.NoWatchJob {
+// lockContext returns a context which is cancelled if the lock is lost so that a pre-empted build stops changing
+// resources before it releases the lock to the newer build
+func (o *Options) lockContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	lockCtx, cancel := context.WithCancel(ctx)
+	if o.Lock == nil {
+		return lockCtx, cancel
+	}
+	go func() {
+		select {
+		case <-o.Lock.Done():
+			cancel()
+		case <-lockCtx.Done():
+		}
+	}()
+	return lockCtx, cancel
+}
+
+// checkLock checks the lock has not been taken or pre-empted by a newer build. If the given resources have already been
+// created they are removed as the newer build replaces them
+func (o *Options) checkLock(ctx context.Context, created []*Resource) error {
+	if o.Lock == nil {
+		return nil
+	}
+	err := o.Lock.Check(ctx)
+	if err == nil {
 		return nil
 	}
-	err = o.watchJob()
+	// resources which are applied are shared with the newer build so must not be removed
+	if len(created) > 0 && !o.Apply {
+		deleteErr := o.deleteResources(ctx, created, "Build was superseded")
+		if deleteErr != nil {
+			log.Logger().Warnf("failed to remove the resources of the superseded build: %s", deleteErr.Error())
+		}
+	}
+	return fmt.Errorf("failed to keep lock: %w", err)
+}
+
+// releaseLock releases the lock if it is held
+func (o *Options) releaseLock(ctx context.Context) {
+	if o.Lock == nil {
+		return
+	}
+	err := o.Lock.Release(ctx)
 	if err != nil {
-		return fmt.Errorf("job failed to complete successfully: %w", err)
+		log.Logger().Warnf("failed to release lock: %s", err.Error())
 	}
+}
 
-	if o.NoDeleteResource {
-		return nil
+// previousRun the state of the primary resource of a previous build which is updated in place
+type previousRun struct {
+	// Generation the generation of the primary resource before it was applied
+	Generation int64
+
+	// JobUIDs the UIDs of the Jobs of the previous build
+	JobUIDs []types.UID
+}
+
+// findPrevious returns the state of the primary resource of a previous build if --apply is enabled and it exists
+func (o *Options) findPrevious(ctx context.Context, primary *Resource) (*previousRun, error) {
+	if !o.Apply {
+		return nil, nil
+	}
+	u, err := o.Client.Get(ctx, primary.Name(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", primary.Kind(), primary.Name(), err)
+	}
+	answer := &previousRun{
+		Generation: u.GetGeneration(),
+	}
+	version := terraforms.VersionForResource(primary.Resource)
+	if version != nil {
+		jobList, err := terraforms.FindTerraformJobs(ctx, o.KubeClient, version, primary.Namespace(), primary.Name())
+		if err != nil {
+			return nil, err
+		}
+		for i := range jobList {
+			if version.IsJob(&jobList[i], primary.Name()) {
+				answer.JobUIDs = append(answer.JobUIDs, jobList[i].UID)
+			}
+		}
+	}
+	log.Logger().Infof("updating %s %s of a previous build in place", primary.Kind(), info(primary.Name()))
+	return answer, nil
+}
 
-	tf, err := dynkube.DynamicResource(o.DynamicClient, ns, gvr).Get(ctx, name, metav1.GetOptions{})
+// createResources creates or applies the resources with the primary resource last so that any resources it uses exist
+// and then makes the primary resource the owner of its dependents
+func (o *Options) createResources(ctx context.Context, resources []*Resource) error {
+	for i := len(resources) - 1; i >= 0; i-- {
+		var err error
+		if o.Apply {
+			err = o.applyResource(ctx, resources[i])
+		} else {
+			err = o.createResource(ctx, resources[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := o.ownDependents(ctx, resources)
 	if err != nil {
-		return fmt.Errorf("failed to find Terraform %s in namespace %s: %w", name, ns, err)
+		primary := resources[0]
+		return fmt.Errorf("failed to add owner references to %s %s: %w", primary.Kind(), primary.Name(), err)
+	}
+	return nil
+}
+
+// deleteResources deletes the primary resource and any dependents it cannot own.
+// Dependents owned by the primary resource are garbage collected once it has been removed.
+// The reason is logged along with each deleted resource
+func (o *Options) deleteResources(ctx context.Context, resources []*Resource, reason string) error {
+	primary := resources[0]
+	for _, r := range resources {
+		if !r.Primary && r.CanBeOwnedBy(primary) {
+			continue
+		}
+		err := r.Client(o.DynamicClient).Delete(ctx, r.Name(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %w", r.Kind(), r.Name(), err)
+		}
+		log.Logger().Infof("%s so deleted %s %s", reason, r.Kind(), info(r.Name()))
+	}
+	return nil
+}
+
+// writeReports writes the result of the test run to the JSON and JUnit report files if specified
+func (o *Options) writeReports() error {
+	o.Report.Redact(o.Redactor.Text)
+	if o.JSONReport != "" {
+		err := o.Report.WriteJSON(o.JSONReport)
+		if err != nil {
+			return fmt.Errorf("failed to write JSON report: %w", err)
+		}
+		log.Logger().Infof("saved JSON report to %s", info(o.JSONReport))
 	}
+	if o.JUnitReport != "" {
+		err := o.Report.WriteJUnit(o.JUnitReport)
+		if err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+		log.Logger().Infof("saved JUnit report to %s", info(o.JUnitReport))
+	}
+	return nil
+}
+
+// deletePreviousResources deletes the resources of each kind matching the test labels
+func (o *Options) deletePreviousResources(ctx context.Context, resources []*Resource) error {
+	selector := dynkube.ToSelector(o.Labels)
+	processed := map[string]bool{}
 
-	labels = tf.GetLabels()
-	if labels != nil {
-		keep := labels["keep"]
-		log.Logger().Infof("test Terraform %s in namespace %s has keep label %s", info(name), info(ns), info(keep))
-		if keep == "yes" || keep == "true" {
-			log.Logger().Infof("not removing the test Terraform %s in namespace %s as it has a keep label", info(name), info(ns))
+	// resources which are applied are updated in place rather than deleted
+	applied := map[string]bool{}
+	if o.Apply {
+		for _, r := range resources {
+			applied[r.Resource.String()+"/"+r.Namespace()+"/"+r.Name()] = true
+		}
+	}
+	for _, resource := range resources {
+		key := resource.Resource.String() + "/" + resource.Namespace()
+		if processed[key] {
+			continue
+		}
+		processed[key] = true
+
+		client := resource.Client(o.DynamicClient)
+		kind := resource.Kind()
+		version := terraforms.VersionForResource(resource.Resource)
+		var deleteErr error
+		err := dynkube.ListPages(ctx, client.List, metav1.ListOptions{LabelSelector: selector}, func(list *unstructured.UnstructuredList) error {
+			for _, r := range list.Items {
+				name := r.GetName()
+				if applied[key+"/"+name] {
+					continue
+				}
+
+				if resource.Primary && version != nil {
+					err := terraforms.DeleteActiveTerraformJobs(ctx, o.KubeClient, version, r.GetNamespace(), name)
+					if err != nil {
+						deleteErr = fmt.Errorf("failed to delete active Terraform Jobs for namespace %s name %s: %w", r.GetNamespace(), name, err)
+						return deleteErr
+					}
+				}
+
+				err := client.Delete(ctx, name, metav1.DeleteOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					deleteErr = fmt.Errorf("failed to delete %s %s: %w", kind, name, err)
+					return deleteErr
+				}
+				log.Logger().Infof("deleted previous pipeline %s %s", kind, info(name))
+			}
 			return nil
+		})
+		if deleteErr != nil {
+			return deleteErr
+		}
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			log.Logger().Debugf("no previous %s resources to delete: %s", kind, err.Error())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s resources with selector %s: %w", kind, selector, err)
 		}
 	}
+	return nil
+}
 
-	err = dynkube.DynamicResource(o.DynamicClient, ns, gvr).Delete(ctx, name, metav1.DeleteOptions{})
+// ownDependents adds an owner reference to the primary resource on all the other resources so that
+// they are removed along with the primary resource
+func (o *Options) ownDependents(ctx context.Context, resources []*Resource) error {
+	primary := resources[0]
+	if len(resources) == 1 {
+		return nil
+	}
+	owner, err := o.Client.Get(ctx, primary.Name(), metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to delete %s %s: %w", kind, name, err)
+		return fmt.Errorf("failed to get %s %s: %w", primary.Kind(), primary.Name(), err)
+	}
+	for _, r := range resources[1:] {
+		if !r.CanBeOwnedBy(primary) {
+			continue
+		}
+		client := r.Client(o.DynamicClient)
+		u, err := client.Get(ctx, r.Name(), metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s: %w", r.Kind(), r.Name(), err)
+		}
+		r.Object = u
+		r.SetOwner(owner)
+		r.Object, err = client.Update(ctx, r.Object, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update %s %s: %w", r.Kind(), r.Name(), err)
+		}
 	}
-	log.Logger().Infof("Job succeeded so deleted %s %s", kind, info(name))
+	return nil
+}
+
+// createResource creates the resource failing if it already exists
+func (o *Options) createResource(ctx context.Context, r *Resource) error {
+	client := r.Client(o.DynamicClient)
+	kind := r.Kind()
+	name := r.Name()
+
+	_, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return fmt.Errorf("should not have a %s called %s", kind, name)
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to check if %s %s exists: %w", kind, name, err)
+	}
+
+	_, err = client.Create(ctx, r.Object, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create %s %s: %w", kind, name, err)
+	}
+	log.Logger().Infof("created %s %s", kind, info(name))
+	return nil
+}
+
+// applyResource creates or updates the resource in place using server-side apply
+func (o *Options) applyResource(ctx context.Context, r *Resource) error {
+	client := r.Client(o.DynamicClient)
+	kind := r.Kind()
+	name := r.Name()
+
+	u, err := client.Apply(ctx, name, r.Object, metav1.ApplyOptions{
+		FieldManager: o.FieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s: %w", kind, name, err)
+	}
+	r.Object = u
+	log.Logger().Infof("applied %s %s", kind, info(name))
 	return nil
 }
 
@@ -264,11 +670,31 @@ func (o *Options) Validate() error {
 	if o.CommandRunner == nil {
 		o.CommandRunner = cmdrunner.DefaultCommandRunner
 	}
+	if o.Apply {
+		if o.FieldManager == "" {
+			return options.MissingOption("field-manager")
+		}
+		// the resource name must be the same for each build so that it can be updated in place
+		o.ResourceName = strings.TrimSuffix(o.ResourceName, "-"+o.BuildNumber)
+	}
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	if o.Validator == nil {
+		o.Validator = schema.NewValidator()
+		err = o.Validator.LoadFiles(o.SchemaFiles...)
+		if err != nil {
+			return options.InvalidOptionf("schema", strings.Join(o.SchemaFiles, ","), "%s", err.Error())
+		}
+	}
 
 	if o.File == "" {
 		return options.MissingOption("file")
 	}
 	exists, err := files.FileExists(o.File)
+	if err == nil && !exists {
+		exists, err = files.DirExists(o.File)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to check if file exists %s: %w", o.File, err)
 	}
@@ -311,6 +737,18 @@ func (o *Options) Validate() error {
 			}
 		}
 	}
+	err = o.splitSecretEnv()
+	if err != nil {
+		return err
+	}
+	o.Redactor, err = redact.New(o.RedactPatterns)
+	if err != nil {
+		return options.InvalidOptionf("redact-pattern", strings.Join(o.RedactPatterns, ","), "%s", err.Error())
+	}
+	o.Redactor.AddEnv(o.Env)
+	for _, v := range o.SecretEnv {
+		o.Redactor.AddValues(v)
+	}
 	if o.Env["JX_VERSION"] == "" {
 		c := &cmdrunner.Command{
 			Name: "jx",
@@ -318,7 +756,11 @@ func (o *Options) Validate() error {
 		}
 		v, err := o.CommandRunner(c)
 		if err != nil {
-			return fmt.Errorf("failed to run command: %s: %w", c.CLI(), err)
+			// templates can be rendered without jx being installed
+			if !o.RenderOnly {
+				return fmt.Errorf("failed to run command: %s: %w", c.CLI(), err)
+			}
+			log.Logger().Warnf("failed to run command: %s: %s", c.CLI(), err.Error())
 		}
 		v = strings.TrimSpace(v)
 		if v == "" {
@@ -329,6 +771,13 @@ func (o *Options) Validate() error {
 		}
 	}
 
+	if o.RenderOnly {
+		if o.RESTMapper == nil {
+			o.RESTMapper = dynkube.NewRESTMapper(nil, dynkube.NewStaticRESTMapper(defaultStaticMappings...))
+		}
+		return nil
+	}
+
 	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
 	if err != nil {
 		return fmt.Errorf("failed to create kube client: %w", err)
@@ -337,6 +786,47 @@ func (o *Options) Validate() error {
 	if err != nil {
 		return fmt.Errorf("failed to craete dynamic client: %w", err)
 	}
+	if o.RESTMapper == nil {
+		o.RESTMapper = dynkube.NewRESTMapper(o.KubeClient.Discovery(), dynkube.NewStaticRESTMapper(defaultStaticMappings...))
+	}
+	return nil
+}
+
+// splitSecretEnv moves the sensitive environment variables into the secret environment
+func (o *Options) splitSecretEnv() error {
+	if o.SecretEnv == nil {
+		o.SecretEnv = map[string]string{}
+	}
+	for _, e := range o.SecretEnvVars {
+		values := strings.SplitN(e, "=", 2)
+		name := values[0]
+		if len(values) == 2 {
+			o.SecretEnv[name] = values[1]
+		} else {
+			v, ok := o.Env[name]
+			if !ok {
+				v, ok = os.LookupEnv(name)
+			}
+			if !ok {
+				return options.InvalidOptionf("secret-env", e, "there is no environment variable %s", name)
+			}
+			o.SecretEnv[name] = v
+		}
+		delete(o.Env, name)
+	}
+
+	if o.SecretEnvPattern != "" {
+		r, err := regexp.Compile(o.SecretEnvPattern)
+		if err != nil {
+			return fmt.Errorf("failed to parse option --secret-env-pattern %s: %w", o.SecretEnvPattern, err)
+		}
+		for k, v := range o.Env {
+			if r.MatchString(k) {
+				o.SecretEnv[k] = v
+				delete(o.Env, k)
+			}
+		}
+	}
 	return nil
 }
 
@@ -348,22 +838,28 @@ func (o *Options) GetContext() context.Context {
 	return o.Ctx
 }
 
-func (o *Options) watchJob() error {
-	// TODO: This should probably be rewritten inline, instead of relying on yet another tool
-	args := []string{"verify", "job", "--name", o.Name, "--namespace", o.Namespace}
-	if o.VerifyResult {
-		args = append(args, "--verify-result")
-	}
-	c := &cmdrunner.Command{
-		Name: "jx",
-		Args: args,
-		Out:  os.Stdout,
-		Err:  os.Stderr,
-		In:   os.Stdin,
-	}
-	_, err := o.CommandRunner(c)
+func (o *Options) watchJob(ctx context.Context, primary *Resource, previous *previousRun) (*jobwatch.Result, error) {
+	w := &jobwatch.Options{
+		KubeClient:   o.KubeClient,
+		Namespace:    o.Namespace,
+		Name:         o.Name,
+		Timeout:      o.JobTimeout,
+		PollPeriod:   o.JobPollPeriod,
+		VerifyResult: o.VerifyResult,
+		Out:          os.Stdout,
+	}
+	version := terraforms.VersionForResource(primary.Resource)
+	if version != nil {
+		w.JobSelector = version.JobSelector(o.Name)
+		w.PodSelector = version.PodSelector(o.Name)
+	}
+	if previous != nil {
+		w.PreviousUIDs = previous.JobUIDs
+	}
+	result, err := w.Watch(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to run %s: %w", c.CLI(), err)
+		return nil, fmt.Errorf("failed to watch Job %s in namespace %s: %w", o.Name, o.Namespace, err)
 	}
-	return nil
+	log.Logger().Infof("Job %s has outcome %s", info(o.Name), info(string(result.Outcome)))
+	return result, nil
 }
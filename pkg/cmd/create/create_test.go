@@ -1,15 +1,35 @@
 package create_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jenkins-x-plugins/jx-test/pkg/jobwatch"
+	"github.com/jenkins-x-plugins/jx-test/pkg/locks"
+	"github.com/jenkins-x-plugins/jx-test/pkg/redact"
+	"github.com/jenkins-x-plugins/jx-test/pkg/report"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
 	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cmdrunner/fakerunner"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
 
 	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/create"
 	"github.com/stretchr/testify/assert"
@@ -67,6 +87,7 @@ func TestCreate(t *testing.T) {
 	buildNumber := "3"
 	prLabel := "pr-" + strconv.Itoa(prNumber)
 	expectedName := "tf-myrepo-pr456-myctx-3"
+	reportDir := t.TempDir()
 
 	scheme := runtime.NewScheme()
 	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
@@ -86,13 +107,32 @@ func TestCreate(t *testing.T) {
 	o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
 	o.File = filepath.Join("test_data", "tf.yaml")
 	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
 	o.CommandRunner = runner.Run
-	o.KubeClient = fake.NewSimpleClientset()
+	o.KubeClient = fake.NewSimpleClientset(newCompletedJob(expectedName, ns))
+	o.JobPollPeriod = time.Millisecond
+	o.JSONReport = filepath.Join(reportDir, "result.json")
+	o.JUnitReport = filepath.Join(reportDir, "junit.xml")
 
 	err := o.Run()
 	require.NoError(t, err, "failed to run create command")
 
+	require.NotNil(t, o.Report, "o.Report")
+	assert.Equal(t, report.OutcomePassed, o.Report.Outcome, "o.Report.Outcome")
+	assert.Equal(t, expectedName, o.Report.Name, "o.Report.Name")
+	assert.Equal(t, string(jobwatch.OutcomeSucceeded), o.Report.JobOutcome, "o.Report.JobOutcome")
+	var phases []string
+	for _, p := range o.Report.Phases {
+		phases = append(phases, p.Name)
+		assert.Equal(t, report.OutcomePassed, p.Outcome, "outcome of phase %s", p.Name)
+	}
+	assert.Equal(t, []string{report.PhaseRender, report.PhaseCleanup, report.PhaseCreate, report.PhaseJob, report.PhaseDelete}, phases, "report phases")
+	assert.FileExists(t, o.JSONReport, "JSON report")
+	assert.FileExists(t, o.JUnitReport, "JUnit report")
+
 	assert.Equal(t, expectedName, o.ResourceName, "o.ResourceName")
+	require.NotNil(t, o.JobResult, "o.JobResult")
+	assert.Equal(t, jobwatch.OutcomeSucceeded, o.JobResult.Outcome, "o.JobResult.Outcome")
 	assert.Equal(t, map[string]string{"context": contextName, "kind": "jx-test", "owner": owner, "pr": prLabel, "repo": repo}, o.Labels, "o.Labels")
 
 	ctx := o.GetContext()
@@ -106,7 +146,624 @@ func TestCreate(t *testing.T) {
 	require.Equal(t, "tf-myrepo-pr999-myctx-3", r.GetName(), "resource[0].Name")
 	require.Equal(t, ns, r.GetNamespace(), "resource[0].Namespace")
 
+	leaseList, err := o.KubeClient.CoordinationV1().Leases(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Leases")
+	assert.Empty(t, leaseList.Items, "should have released the lock")
+
 	for _, c := range runner.OrderedCommands {
 		t.Logf("faked: %s\n", c.CLI())
 	}
 }
+
+func TestCreateMultipleDocuments(t *testing.T) {
+	ns := "jx"
+	expectedName := "tf-myrepo-pr456-myctx-3"
+
+	previousConfigMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-456
+    repo: myrepo
+  name: tf-myrepo-pr456-myctx-2-vars
+  namespace: jx
+`
+	scheme := runtime.NewScheme()
+	dynObjects := tftests.ParseUnstructureds(t, nil, append(testResources, previousConfigMap))
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+
+	_, o := create.NewCmdCreate()
+	o.PullRequestNumber = 456
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.BuildNumber = "3"
+	o.Namespace = ns
+	o.ResourceNamePrefix = "tf-"
+	o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
+	o.File = filepath.Join("test_data", "multi")
+	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
+	o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+	o.KubeClient = fake.NewSimpleClientset(newCompletedJob(expectedName, ns))
+	o.JobPollPeriod = time.Millisecond
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run create command")
+
+	ctx := o.GetContext()
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	require.Len(t, list.Items, 1, "should have removed previous PR resources and the primary resource")
+
+	testCases := []struct {
+		gvr  schema.GroupVersionResource
+		name string
+	}{
+		{gvr: tftests.ConfigMapResource, name: expectedName + "-vars"},
+		{gvr: tftests.SecretResource, name: expectedName + "-secrets"},
+	}
+	for _, tc := range testCases {
+		list, err = fakeDynClient.Resource(tc.gvr).Namespace(ns).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err, "failed to list %s", tc.gvr.Resource)
+		require.Len(t, list.Items, 1, "should have one %s", tc.gvr.Resource)
+
+		r := list.Items[0]
+		assert.Equal(t, tc.name, r.GetName(), "%s name", tc.gvr.Resource)
+		assert.Equal(t, "jx-test", r.GetLabels()["kind"], "%s kind label", tc.gvr.Resource)
+		assert.Equal(t, "pr-456", r.GetLabels()["pr"], "%s pr label", tc.gvr.Resource)
+		assert.Equal(t, "3", r.GetLabels()["build"], "%s build label", tc.gvr.Resource)
+
+		owners := r.GetOwnerReferences()
+		require.Len(t, owners, 1, "%s owner references", tc.gvr.Resource)
+		assert.Equal(t, "Terraform", owners[0].Kind, "%s owner kind", tc.gvr.Resource)
+		assert.Equal(t, expectedName, owners[0].Name, "%s owner name", tc.gvr.Resource)
+	}
+}
+
+func TestCreateApply(t *testing.T) {
+	ns := "jx"
+	expectedName := "tf-myrepo-pr456-myctx"
+
+	scheme := runtime.NewScheme()
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+	kubeClient := fake.NewSimpleClientset()
+
+	// lets emulate the operator replacing the Job whenever the spec changes
+	jobCount := 0
+	tftests.AddFakeApplyReactor(fakeDynClient, func(gvr schema.GroupVersionResource, u *unstructured.Unstructured) {
+		if gvr != terraforms.V1Alpha1.Resource {
+			return
+		}
+		ctx := context.TODO()
+		jobCount++
+		err := kubeClient.BatchV1().Jobs(ns).Delete(ctx, u.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			assert.NoError(t, err, "failed to delete Job")
+		}
+		job := newCompletedJob(u.GetName(), ns)
+		job.UID = types.UID("job-" + strconv.Itoa(jobCount))
+		_, err = kubeClient.BatchV1().Jobs(ns).Create(ctx, job, metav1.CreateOptions{})
+		assert.NoError(t, err, "failed to create Job")
+	})
+
+	run := func(buildNumber string, envVars ...string) *create.Options {
+		_, o := create.NewCmdCreate()
+		o.PullRequestNumber = 456
+		o.RepoOwner = "myowner"
+		o.RepoName = "myrepo"
+		o.Context = "myctx"
+		o.BuildNumber = buildNumber
+		o.Namespace = ns
+		o.ResourceNamePrefix = "tf-"
+		o.Apply = true
+		o.EnvVars = envVars
+		o.File = filepath.Join("test_data", "tf.yaml")
+		o.DynamicClient = fakeDynClient
+		o.RESTMapper = tftests.NewFakeRESTMapper()
+		o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+		o.KubeClient = kubeClient
+		o.JobPollPeriod = time.Millisecond
+
+		err := o.Run()
+		require.NoError(t, err, "failed to run create command for build %s", buildNumber)
+		assert.Equal(t, expectedName, o.ResourceName, "o.ResourceName for build %s", buildNumber)
+		return o
+	}
+
+	o := run("3", "TF_VAR_cluster_name=pr-2127-5-gke-gsm", "TF_VAR_gcp_project=jenkins-x-labs-bdd")
+	require.NotNil(t, o.JobResult, "o.JobResult")
+	assert.Equal(t, "job-1", string(o.JobResult.Job.UID), "should have watched the Job of the first build")
+
+	ctx := o.GetContext()
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	var names []string
+	for i := range list.Items {
+		names = append(names, list.Items[i].GetName())
+	}
+	assert.ElementsMatch(t, []string{expectedName, "tf-myrepo-pr999-myctx-3"}, names, "should have removed the resources of previous builds but kept the applied resource")
+
+	// the env changes so the Terraform is updated in place and a new Job is watched
+	o = run("4", "TF_VAR_cluster_name=pr-2127-6-gke-gsm", "TF_VAR_gcp_project=jenkins-x-labs-bdd")
+	require.NotNil(t, o.JobResult, "o.JobResult")
+	assert.Equal(t, "job-2", string(o.JobResult.Job.UID), "should have watched the Job of the second build")
+
+	tf, err := o.Client.Get(ctx, expectedName, metav1.GetOptions{})
+	require.NoError(t, err, "failed to get %s", expectedName)
+	assert.Equal(t, int64(2), tf.GetGeneration(), "generation")
+	assert.Equal(t, "4", tf.GetLabels()["build"], "build label")
+
+	// nothing in the spec changes so there is no Job to watch
+	o = run("5", "TF_VAR_cluster_name=pr-2127-6-gke-gsm", "TF_VAR_gcp_project=jenkins-x-labs-bdd")
+	assert.Nil(t, o.JobResult, "o.JobResult")
+	assert.Equal(t, 2, jobCount, "should not have created a Job")
+	assert.Equal(t, report.OutcomeSkipped, o.Report.Phases[len(o.Report.Phases)-1].Outcome, "delete phase outcome")
+}
+
+func TestCreateApplyDependents(t *testing.T) {
+	ns := "jx"
+	expectedName := "tf-myrepo-pr456-myctx"
+
+	scheme := runtime.NewScheme()
+	fakeDynClient := tftests.NewFakeDynClient(scheme)
+	tftests.AddFakeApplyReactor(fakeDynClient, nil)
+	kubeClient := fake.NewSimpleClientset(newCompletedJob(expectedName, ns))
+
+	for _, buildNumber := range []string{"3", "4"} {
+		_, o := create.NewCmdCreate()
+		o.PullRequestNumber = 456
+		o.RepoOwner = "myowner"
+		o.RepoName = "myrepo"
+		o.Context = "myctx"
+		o.BuildNumber = buildNumber
+		o.Namespace = ns
+		o.ResourceNamePrefix = "tf-"
+		o.Apply = true
+		o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
+		o.File = filepath.Join("test_data", "multi")
+		o.DynamicClient = fakeDynClient
+		o.RESTMapper = tftests.NewFakeRESTMapper()
+		o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+		o.KubeClient = kubeClient
+		o.JobPollPeriod = time.Millisecond
+
+		err := o.Run()
+		require.NoError(t, err, "failed to run create command for build %s", buildNumber)
+	}
+
+	ctx := context.TODO()
+	for _, gvr := range []schema.GroupVersionResource{tftests.ConfigMapResource, tftests.SecretResource} {
+		list, err := fakeDynClient.Resource(gvr).Namespace(ns).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err, "failed to list %s", gvr.Resource)
+		require.Len(t, list.Items, 1, "should have one %s", gvr.Resource)
+
+		owners := list.Items[0].GetOwnerReferences()
+		require.Len(t, owners, 1, "%s owner references should not be duplicated by later builds", gvr.Resource)
+		assert.Equal(t, expectedName, owners[0].Name, "%s owner name", gvr.Resource)
+	}
+}
+
+func TestCreateApplyV1Beta1(t *testing.T) {
+	ns := "jx"
+	expectedName := "tf-myrepo-pr456-myctx"
+	templateFile := filepath.Join(t.TempDir(), "tf.yaml")
+	err := os.WriteFile(templateFile, []byte(`apiVersion: tf.galleybytes.com/v1beta1
+kind: Terraform
+spec:
+  terraformVersion: 1.1.7
+  terraformModule:
+    source: https://github.com/jenkins-x-bdd/infra-{{ .Env.TF_VAR_cluster_name }}-dev
+`), 0o600)
+	require.NoError(t, err, "failed to write %s", templateFile)
+
+	scheme := runtime.NewScheme()
+	fakeDynClient := tftests.NewFakeDynClient(scheme)
+	kubeClient := fake.NewSimpleClientset()
+
+	// lets emulate the v1beta1 operator which keeps the Jobs of earlier generations and labels them with the resource name
+	jobCount := 0
+	tftests.AddFakeApplyReactor(fakeDynClient, func(gvr schema.GroupVersionResource, u *unstructured.Unstructured) {
+		if gvr != terraforms.V1Beta1.Resource {
+			return
+		}
+		jobCount++
+		job := newCompletedJob(u.GetName()+"-"+strconv.Itoa(jobCount)+"-apply", ns)
+		job.UID = types.UID("job-" + strconv.Itoa(jobCount))
+		job.Labels = map[string]string{terraforms.V1Beta1.ResourceLabel: u.GetName()}
+		_, err := kubeClient.BatchV1().Jobs(ns).Create(context.TODO(), job, metav1.CreateOptions{})
+		assert.NoError(t, err, "failed to create Job")
+	})
+
+	run := func(buildNumber, clusterName string) *create.Options {
+		_, o := create.NewCmdCreate()
+		o.PullRequestNumber = 456
+		o.RepoOwner = "myowner"
+		o.RepoName = "myrepo"
+		o.Context = "myctx"
+		o.BuildNumber = buildNumber
+		o.Namespace = ns
+		o.ResourceNamePrefix = "tf-"
+		o.Apply = true
+		o.EnvVars = []string{"TF_VAR_cluster_name=" + clusterName}
+		o.File = templateFile
+		o.NoValidateSchema = true
+		o.DynamicClient = fakeDynClient
+		o.RESTMapper = tftests.NewFakeRESTMapper()
+		o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+		o.KubeClient = kubeClient
+		o.JobPollPeriod = time.Millisecond
+		o.JobTimeout = 5 * time.Second
+
+		err := o.Run()
+		require.NoError(t, err, "failed to run create command for build %s", buildNumber)
+		assert.Equal(t, expectedName, o.ResourceName, "o.ResourceName for build %s", buildNumber)
+		return o
+	}
+
+	o := run("3", "pr-2127-5-gke-gsm")
+	require.NotNil(t, o.JobResult, "o.JobResult")
+	require.NotNil(t, o.JobResult.Job, "o.JobResult.Job")
+	assert.Equal(t, expectedName+"-1-apply", o.JobResult.Job.Name, "should have watched the Job labelled with the resource name")
+
+	// the completed Job of the first build still exists so only the new Job should be watched
+	o = run("4", "pr-2127-6-gke-gsm")
+	require.NotNil(t, o.JobResult, "o.JobResult")
+	require.NotNil(t, o.JobResult.Job, "o.JobResult.Job")
+	assert.Equal(t, "job-2", string(o.JobResult.Job.UID), "should have ignored the Job of the first build")
+}
+
+func TestCreateFailsToListPreviousResources(t *testing.T) {
+	ns := "jx"
+	scheme := runtime.NewScheme()
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+	fakeDynClient.PrependReactor("list", "terraforms", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(terraforms.V1Alpha1.Resource.GroupResource(), "", fmt.Errorf("not allowed"))
+	})
+
+	_, o := create.NewCmdCreate()
+	o.PullRequestNumber = 456
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.BuildNumber = "3"
+	o.Namespace = ns
+	o.ResourceNamePrefix = "tf-"
+	o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
+	o.File = filepath.Join("test_data", "tf.yaml")
+	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
+	o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err := o.Run()
+	require.Error(t, err, "should fail if the previous resources cannot be listed")
+	assert.True(t, apierrors.IsForbidden(err), "should return the list error: %s", err.Error())
+}
+
+func TestCreateSuperseded(t *testing.T) {
+	ns := "jx"
+	labels := map[string]string{"context": "myctx", "kind": "jx-test", "owner": "myowner", "pr": "pr-456", "repo": "myrepo"}
+	holder := "newer-build-pod"
+	seconds := int32(300)
+	renewed := metav1.NewMicroTime(time.Now())
+	kubeClient := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        locks.LeaseName(labels),
+			Namespace:   ns,
+			Annotations: map[string]string{locks.AnnotationBuild: "4"},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &renewed,
+		},
+	})
+
+	scheme := runtime.NewScheme()
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+
+	_, o := create.NewCmdCreate()
+	o.PullRequestNumber = 456
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.BuildNumber = "3"
+	o.Namespace = ns
+	o.ResourceNamePrefix = "tf-"
+	o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
+	o.File = filepath.Join("test_data", "tf.yaml")
+	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
+	o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.ErrorIs(t, err, locks.ErrSuperseded, "should be superseded by build 4")
+
+	list, err := fakeDynClient.Resource(terraforms.V1Alpha1.Resource).Namespace(ns).List(o.GetContext(), metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	assert.Len(t, list.Items, len(testResources), "should not have removed the resources of other builds")
+}
+
+func TestCreateSecretEnv(t *testing.T) {
+	ns := "jx"
+	expectedName := "tf-myrepo-pr456-myctx-3"
+	secretName := expectedName + "-env"
+	secretValues := []string{"s3cr3t-t0ken", "jenkins-x-labs-bdd"}
+
+	scheme := runtime.NewScheme()
+	fakeDynClient := tftests.NewFakeDynClient(scheme)
+
+	newOptions := func() *create.Options {
+		_, o := create.NewCmdCreate()
+		o.PullRequestNumber = 456
+		o.RepoOwner = "myowner"
+		o.RepoName = "myrepo"
+		o.Context = "myctx"
+		o.BuildNumber = "3"
+		o.Namespace = ns
+		o.ResourceNamePrefix = "tf-"
+		o.EnvVars = []string{"TF_VAR_gcp_project=" + secretValues[1], "TF_VAR_cluster_name=pr-2127-5-gke-gsm", "JX_VERSION=3.10.0"}
+		o.SecretEnvVars = []string{"TF_VAR_token=" + secretValues[0]}
+		o.SecretEnvPattern = "_PROJECT$|_project$"
+		o.File = filepath.Join("test_data", "tf.yaml")
+		return o
+	}
+
+	o := newOptions()
+	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
+	o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+	o.KubeClient = fake.NewSimpleClientset(newCompletedJob(expectedName, ns))
+	o.JobPollPeriod = time.Millisecond
+	o.NoDeleteResource = true
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run create command")
+	assert.Equal(t, secretName, o.SecretName, "o.SecretName")
+	assert.NotContains(t, o.Env, "TF_VAR_gcp_project", "should not pass sensitive env vars to the template")
+
+	ctx := o.GetContext()
+	tf, err := o.Client.Get(ctx, expectedName, metav1.GetOptions{})
+	require.NoError(t, err, "failed to get %s", expectedName)
+	data, err := yaml.Marshal(tf.Object)
+	require.NoError(t, err, "failed to marshal %s", expectedName)
+	for _, v := range secretValues {
+		assert.NotContains(t, string(data), v, "Terraform should not contain the sensitive values")
+	}
+
+	env, _, err := unstructured.NestedSlice(tf.Object, "spec", "env")
+	require.NoError(t, err, "failed to get spec.env")
+	refs := map[string]string{}
+	for _, e := range env {
+		m := e.(map[string]interface{})
+		name, _, _ := unstructured.NestedString(m, "name")
+		ref, found, _ := unstructured.NestedString(m, "valueFrom", "secretKeyRef", "name")
+		if found && ref == secretName {
+			key, _, _ := unstructured.NestedString(m, "valueFrom", "secretKeyRef", "key")
+			assert.Equal(t, name, key, "secretKeyRef key for %s", name)
+			refs[name] = ref
+		}
+	}
+	assert.Equal(t, secretName, refs["TF_VAR_token"], "secretKeyRef of TF_VAR_token")
+	assert.Equal(t, secretName, refs["TF_VAR_gcp_project"], "secretKeyRef of TF_VAR_gcp_project")
+
+	secret, err := fakeDynClient.Resource(tftests.SecretResource).Namespace(ns).Get(ctx, secretName, metav1.GetOptions{})
+	require.NoError(t, err, "failed to get Secret %s", secretName)
+	stringData, _, err := unstructured.NestedStringMap(secret.Object, "stringData")
+	require.NoError(t, err, "failed to get stringData")
+	assert.Equal(t, map[string]string{"TF_VAR_token": secretValues[0], "TF_VAR_gcp_project": secretValues[1]}, stringData, "Secret stringData")
+	assert.Equal(t, "pr-456", secret.GetLabels()["pr"], "Secret pr label")
+	owners := secret.GetOwnerReferences()
+	require.Len(t, owners, 1, "Secret owner references")
+	assert.Equal(t, expectedName, owners[0].Name, "Secret owner name")
+
+	// the rendered output should be redacted
+	out := &bytes.Buffer{}
+	o = newOptions()
+	o.RenderOnly = true
+	o.Out = out
+	err = o.Run()
+	require.NoError(t, err, "failed to render")
+	text := out.String()
+	for _, v := range secretValues {
+		assert.NotContains(t, text, v, "rendered output should not contain the sensitive values")
+	}
+	assert.Contains(t, text, "TF_VAR_token: '"+redact.Mask+"'", "rendered output should contain redacted values")
+}
+
+func TestCreateRedactReport(t *testing.T) {
+	token := "my-b0t-t0ken"
+	reportDir := t.TempDir()
+	templateFile := filepath.Join(reportDir, "invalid.yaml")
+	err := os.WriteFile(templateFile, []byte(`apiVersion: v1
+kind: ConfigMap
+data:
+  token: [{{ .Env.TF_VAR_bot_token }}
+`), 0o600)
+	require.NoError(t, err, "failed to write %s", templateFile)
+
+	_, o := create.NewCmdCreate()
+	o.PullRequestNumber = 456
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.BuildNumber = "3"
+	o.ResourceNamePrefix = "tf-"
+	o.EnvVars = []string{"TF_VAR_bot_token=" + token, "JX_VERSION=3.10.0"}
+	o.File = templateFile
+	o.RenderOnly = true
+	o.JSONReport = filepath.Join(reportDir, "result.json")
+	o.JUnitReport = filepath.Join(reportDir, "junit.xml")
+
+	err = o.Run()
+	require.Error(t, err, "should fail to parse the template")
+
+	for _, path := range []string{o.JSONReport, o.JUnitReport} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err, "failed to load %s", path)
+		assert.NotContains(t, string(data), token, "%s should not contain the token", path)
+		assert.Contains(t, string(data), redact.Mask, "%s should contain the masked token", path)
+	}
+}
+
+func TestCreateInvalidSchema(t *testing.T) {
+	ns := "jx"
+	crd, err := os.ReadFile(filepath.Join("..", "..", "schema", "test_data", "terraform-crd.yaml"))
+	require.NoError(t, err, "failed to load CRD")
+	templateFile := filepath.Join(t.TempDir(), "tf.yaml")
+	err = os.WriteFile(templateFile, []byte(`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+spec:
+  terraformVerison: 0.13.4
+  terraformModule:
+    address: https://github.com/jenkins-x-bdd/infra-{{ .Env.TF_VAR_cluster_name }}-dev
+`), 0o600)
+	require.NoError(t, err, "failed to write %s", templateFile)
+
+	scheme := runtime.NewScheme()
+	dynObjects := tftests.ParseUnstructureds(t, nil, append(testResources, strings.SplitN(string(crd), "---", 2)[0]))
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+
+	_, o := create.NewCmdCreate()
+	o.PullRequestNumber = 456
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.BuildNumber = "3"
+	o.Namespace = ns
+	o.ResourceNamePrefix = "tf-"
+	o.EnvVars = []string{"TF_VAR_cluster_name=pr-2127-5-gke-gsm"}
+	o.File = templateFile
+	o.DynamicClient = fakeDynClient
+	o.RESTMapper = tftests.NewFakeRESTMapper()
+	o.CommandRunner = (&fakerunner.FakeRunner{}).Run
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err = o.Run()
+	require.Error(t, err, "should have failed to validate the schema")
+	assert.Contains(t, err.Error(), "spec.terraformVerison is an unknown field", "error")
+	t.Logf("got expected error: %s", err.Error())
+
+	list, err := fakeDynClient.Resource(terraforms.V1Alpha1.Resource).Namespace(ns).List(o.GetContext(), metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	assert.Len(t, list.Items, len(testResources), "should not have removed the previous resources or created the invalid resource")
+}
+
+func TestCreateRenderOnly(t *testing.T) {
+	schemaFile := filepath.Join("..", "..", "schema", "test_data", "terraform-crd.yaml")
+	invalidFile := filepath.Join(t.TempDir(), "invalid.yaml")
+	err := os.WriteFile(invalidFile, []byte(`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+spec:
+  terraformModule:
+    address: https://github.com/jenkins-x-bdd/infra-{{ .Env.TF_VAR_cluster_name }}-dev
+  applyOnCreate: "yes"
+`), 0o600)
+	require.NoError(t, err, "failed to write %s", invalidFile)
+	namespacedFile := filepath.Join(t.TempDir(), "namespaced.yaml")
+	err = os.WriteFile(namespacedFile, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Name }}-vars
+  namespace: other
+`), 0o600)
+	require.NoError(t, err, "failed to write %s", namespacedFile)
+
+	testCases := []struct {
+		name        string
+		file        string
+		expectError string
+		expectYAML  []string
+	}{
+		{
+			name: "single",
+			file: filepath.Join("test_data", "tf.yaml"),
+			expectYAML: []string{
+				"kind: Terraform",
+				"name: tf-myrepo-pr456-myctx-3",
+				"address: https://github.com/jenkins-x-bdd/infra-pr-2127-5-gke-gsm-dev",
+			},
+		},
+		{
+			name: "multi",
+			file: filepath.Join("test_data", "multi"),
+			expectYAML: []string{
+				"kind: Terraform",
+				"---",
+				"kind: ConfigMap",
+				"kind: Secret",
+			},
+		},
+		{
+			name: "namespaced",
+			file: namespacedFile,
+			expectYAML: []string{
+				"kind: ConfigMap",
+				"namespace: other",
+			},
+		},
+		{
+			name:        "invalid",
+			file:        invalidFile,
+			expectError: "spec.applyOnCreate",
+		},
+	}
+
+	for _, tc := range testCases {
+		out := &bytes.Buffer{}
+		_, o := create.NewCmdCreate()
+		o.PullRequestNumber = 456
+		o.RepoOwner = "myowner"
+		o.RepoName = "myrepo"
+		o.Context = "myctx"
+		o.BuildNumber = "3"
+		o.ResourceNamePrefix = "tf-"
+		o.EnvVars = []string{"TF_VAR_gcp_project=jenkins-x-labs-bdd", "TF_VAR_cluster_name=pr-2127-5-gke-gsm", "JX_VERSION=3.10.0"}
+		o.File = tc.file
+		o.RenderOnly = true
+		o.SchemaFiles = []string{schemaFile}
+		o.Out = out
+
+		err := o.Run()
+		if tc.expectError != "" {
+			require.Error(t, err, "for %s", tc.name)
+			assert.Contains(t, err.Error(), tc.expectError, "for %s", tc.name)
+			assert.Equal(t, report.OutcomeFailed, o.Report.Outcome, "o.Report.Outcome for %s", tc.name)
+			continue
+		}
+		require.NoError(t, err, "failed to render %s", tc.name)
+		assert.Nil(t, o.KubeClient, "should not create a kube client for %s", tc.name)
+		assert.Nil(t, o.DynamicClient, "should not create a dynamic client for %s", tc.name)
+
+		text := out.String()
+		for _, expected := range tc.expectYAML {
+			assert.Contains(t, text, expected, "output for %s", tc.name)
+		}
+		t.Logf("%s rendered:\n%s\n", tc.name, text)
+	}
+}
+
+func newCompletedJob(name, ns string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{
+					Type:   batchv1.JobComplete,
+					Status: corev1.ConditionTrue,
+				},
+			},
+		},
+	}
+}
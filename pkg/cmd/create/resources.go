@@ -0,0 +1,371 @@
+package create
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/naming"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/templater"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// AnnotationPrimary the annotation used to mark the primary resource of a multi document template
+	// whose job is watched
+	AnnotationPrimary = "jx-test.jenkins-x.io/primary"
+)
+
+// Resource a resource generated from the templates
+type Resource struct {
+	// Object the generated object
+	Object *unstructured.Unstructured
+
+	// Resource the resource of the object
+	Resource schema.GroupVersionResource
+
+	// Primary whether this is the primary resource whose job is watched
+	Primary bool
+}
+
+// Kind returns the kind of the resource
+func (r *Resource) Kind() string {
+	return r.Object.GetKind()
+}
+
+// Name returns the name of the resource
+func (r *Resource) Name() string {
+	return r.Object.GetName()
+}
+
+// Namespace returns the namespace of the resource or an empty string if it is cluster scoped
+func (r *Resource) Namespace() string {
+	return r.Object.GetNamespace()
+}
+
+// Client returns the dynamic client for the resource
+func (r *Resource) Client(dynamicClient dynamic.Interface) dynamic.ResourceInterface {
+	return dynkube.DynamicResource(dynamicClient, r.Namespace(), r.Resource)
+}
+
+// CanBeOwnedBy returns true if the resource can have an owner reference to the given owner which is only
+// possible if the owner is cluster scoped or they are in the same namespace
+func (r *Resource) CanBeOwnedBy(owner *Resource) bool {
+	return owner.Namespace() == "" || owner.Namespace() == r.Namespace()
+}
+
+// SetOwner adds an owner reference to the owner so that the resource is garbage collected with its owner replacing
+// any existing reference to the same owner
+func (r *Resource) SetOwner(owner *unstructured.Unstructured) {
+	ref := metav1.OwnerReference{
+		APIVersion: owner.GetAPIVersion(),
+		Kind:       owner.GetKind(),
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+	}
+	refs := r.Object.GetOwnerReferences()
+	for i := range refs {
+		if refs[i].UID == ref.UID {
+			refs[i] = ref
+			r.Object.SetOwnerReferences(refs)
+			return
+		}
+	}
+	r.Object.SetOwnerReferences(append(refs, ref))
+}
+
+// LoadResources evaluates the template file or directory of template files and returns the generated resources
+// with the test labels, names and namespaces applied. The primary resource is always the first resource returned
+func (o *Options) LoadResources() ([]*Resource, error) {
+	paths, err := o.templateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Labels["kind"] == "" {
+		o.Labels["kind"] = terraforms.LabelValueKindTest
+	}
+	if len(o.SecretEnv) > 0 {
+		o.SecretName = o.ResourceName + "-env"
+	}
+
+	var resources []*Resource
+	var primary *Resource
+	for _, path := range paths {
+		output, err := o.evaluateTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+		objects, err := ParseObjects(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the template of file %s has YAML: %s: %w", path, o.Redactor.Text(output), err)
+		}
+		if o.LogResource && !o.RenderOnly {
+			text, err := o.redactedYAML(objects)
+			if err != nil {
+				return nil, err
+			}
+			log.Logger().Infof("generated template: %s", text)
+		}
+		for _, u := range objects {
+			r, err := o.toResource(path, u)
+			if err != nil {
+				return nil, err
+			}
+			if r.Primary {
+				if primary != nil {
+					return nil, fmt.Errorf("file %s has %s %s marked as primary but %s %s is already primary", path, r.Kind(), r.Name(), primary.Kind(), primary.Name())
+				}
+				primary = r
+			}
+			resources = append(resources, r)
+		}
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resources generated from %s", o.File)
+	}
+
+	// if no resource is annotated as primary lets use the first one
+	if primary == nil {
+		primary = resources[0]
+		primary.Primary = true
+	}
+	primary.Object.SetName(o.ResourceName)
+
+	answer := []*Resource{primary}
+	for _, r := range resources {
+		if r == primary {
+			continue
+		}
+		// resources which cannot be garbage collected with the primary resource are annotated so gc can remove them
+		if !r.CanBeOwnedBy(primary) {
+			annotations := r.Object.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[terraforms.AnnotationOwner] = terraforms.OwnerAnnotation(&terraforms.Owner{
+				APIVersion: primary.Object.GetAPIVersion(),
+				Kind:       primary.Kind(),
+				Namespace:  primary.Namespace(),
+				Name:       primary.Name(),
+			})
+			r.Object.SetAnnotations(annotations)
+		}
+		answer = append(answer, r)
+	}
+
+	secret, err := o.secretEnvResource(primary)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		answer = append(answer, secret)
+	}
+	return answer, nil
+}
+
+// secretEnvResource creates the Secret of the sensitive environment variables and references them from the
+// primary resource if it is a Terraform resource. Returns nil if there are no sensitive environment variables
+func (o *Options) secretEnvResource(primary *Resource) (*Resource, error) {
+	if len(o.SecretEnv) == 0 {
+		return nil, nil
+	}
+	stringData := map[string]interface{}{}
+	var keys []string
+	for k, v := range o.SecretEnv {
+		stringData[k] = v
+		keys = append(keys, k)
+	}
+	u := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name": o.SecretName,
+			},
+			"type":       "Opaque",
+			"stringData": stringData,
+		},
+	}
+	r, err := o.toResource(o.File, u)
+	if err != nil {
+		return nil, err
+	}
+
+	version := terraforms.VersionForResource(primary.Resource)
+	if version == nil {
+		log.Logger().Warnf("%s %s is not a Terraform resource so its template should reference the sensitive environment variables in the Secret %s", primary.Kind(), primary.Name(), o.SecretName)
+		return r, nil
+	}
+	err = terraforms.AddSecretEnv(version, primary.Object, o.SecretName, keys)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// redactedYAML returns the objects as a multi document YAML with any sensitive values masked
+func (o *Options) redactedYAML(objects []*unstructured.Unstructured) (string, error) {
+	buf := &strings.Builder{}
+	for i, u := range objects {
+		data, err := yaml.Marshal(o.Redactor.Object(u).Object)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal %s %s to YAML: %w", u.GetKind(), u.GetName(), err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	return buf.String(), nil
+}
+
+// ParseObjects parses the objects in the given multi document YAML ignoring any empty documents
+func ParseObjects(text string) ([]*unstructured.Unstructured, error) {
+	var answer []*unstructured.Unstructured
+	reader := kyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(text)))
+	for {
+		data, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return answer, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read YAML document: %w", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		u := &unstructured.Unstructured{}
+		err = yaml.Unmarshal(data, &u.Object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML document: %w", err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+		answer = append(answer, u)
+	}
+}
+
+// templateFiles returns the template file or the YAML files in the template directory in name order
+func (o *Options) templateFiles() ([]string, error) {
+	fileInfo, err := os.Stat(o.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", o.File, err)
+	}
+	if !fileInfo.IsDir() {
+		return []string{o.File}, nil
+	}
+
+	entries, err := os.ReadDir(o.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dir %s: %w", o.File, err)
+	}
+	var answer []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		answer = append(answer, filepath.Join(o.File, e.Name()))
+	}
+	if len(answer) == 0 {
+		return nil, fmt.Errorf("no *.yaml or *.yml files found in dir %s", o.File)
+	}
+	sort.Strings(answer)
+	return answer, nil
+}
+
+func (o *Options) evaluateTemplate(path string) (string, error) {
+	templateText, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	funcMap := sprig.TxtFuncMap()
+	output, err := templater.Evaluate(funcMap, o, string(templateText), path, "resource template")
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate template %s: %w", path, err)
+	}
+	return output, nil
+}
+
+// toResource validates the object, resolves its resource and applies the test labels, name and namespace
+func (o *Options) toResource(path string, u *unstructured.Unstructured) (*Resource, error) {
+	kind := u.GetKind()
+	apiVersion := u.GetAPIVersion()
+	if kind == "" {
+		return nil, fmt.Errorf("generated template of file %s has missing kind", path)
+	}
+	if apiVersion == "" {
+		return nil, fmt.Errorf("generated template of file %s has missing apiVersion", path)
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse apiVersion: %s: %w", apiVersion, err)
+	}
+	gvk := gv.WithKind(kind)
+	gvr, namespaced, err := dynkube.ResourceMapping(o.RESTMapper, gvk)
+	if err != nil {
+		if !o.RenderOnly {
+			return nil, fmt.Errorf("failed to resolve the resource of file %s: %w", path, err)
+		}
+		// without a cluster to discover the resource lets assume the usual plural of a namespaced kind
+		gvr, _ = meta.UnsafeGuessKindToResource(gvk)
+		namespaced = true
+		log.Logger().Debugf("guessing resource %s for %s: %s", gvr.String(), gvk.String(), err.Error())
+	}
+
+	// modify labels
+	labels := u.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range o.Labels {
+		labels[k] = v
+	}
+	// the build label is not part of the selector so that resources of previous builds are replaced
+	if o.BuildNumber != "" {
+		labels[terraforms.LabelBuild] = naming.ToValidValue(o.BuildNumber)
+	}
+	u.SetLabels(labels)
+
+	r := &Resource{
+		Object:   u,
+		Resource: gvr,
+	}
+	if u.GetAnnotations()[AnnotationPrimary] == "true" {
+		r.Primary = true
+	}
+
+	// modify name
+	if u.GetName() == "" {
+		u.SetName(o.ResourceName)
+	}
+	switch {
+	case !namespaced:
+		u.SetNamespace("")
+	case u.GetNamespace() == "" && o.Namespace != "":
+		u.SetNamespace(o.Namespace)
+	}
+	return r, nil
+}
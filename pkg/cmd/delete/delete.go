@@ -0,0 +1,301 @@
+package delete
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/root"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/helper"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/templates"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/pipelinectx"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	info = termcolor.ColorInfo
+
+	cmdLong = templates.LongDesc(`
+		Deletes the test resources of a Pull Request and context or of the given name
+`)
+
+	cmdExample = templates.Examples(`
+		%s delete --repo myrepo --pr 123 --context pr
+
+		# delete a test by name waiting for Terraform to destroy it and then removing its state
+		%s delete --name tf-myrepo-pr123-pr-1 --wait
+	`)
+)
+
+// Options the options for the command
+type Options struct {
+	pipelinectx.Options
+
+	Name                string
+	Namespace           string
+	Wait                bool
+	GracePeriod         time.Duration
+	PollPeriod          time.Duration
+	TerraformAPIVersion string
+	TerraformVersion    *terraforms.Version
+	KubeClient          kubernetes.Interface
+	DynamicClient       dynamic.Interface
+	Ctx                 context.Context
+	Client              dynamic.ResourceInterface
+	Deleted             map[string]terraforms.DeletePath
+}
+
+// NewCmdDelete creates a command object for the command
+func NewCmdDelete() (*cobra.Command, *Options) {
+	o := &Options{}
+
+	cmd := &cobra.Command{
+		Use:     "delete",
+		Short:   "Deletes the test resources of a Pull Request and context",
+		Long:    cmdLong,
+		Example: fmt.Sprintf(cmdExample, root.BinaryName, root.BinaryName),
+		Run: func(_ *cobra.Command, _ []string) {
+			err := o.Run()
+			helper.CheckErr(err)
+		},
+	}
+
+	if o.Ctx == nil {
+		o.Ctx = cmd.Context()
+	}
+	err := o.EnvironmentDefaults(o.GetContext())
+	if err != nil {
+		log.Logger().Warnf("failed to default env vars: %s", err.Error())
+	}
+	// only filter by build if explicitly requested so that all the builds of the context are deleted by default
+	o.BuildNumber = ""
+
+	o.Options.AddFlags(cmd)
+
+	cmd.Flags().StringVarP(&o.Name, "name", "", "", "the name of the test resource to delete. If not specified the test resources matching the pipeline context are deleted")
+	cmd.Flags().StringVarP(&o.Namespace, "ns", "n", "", "the namespace of the test resources")
+	cmd.Flags().BoolVarP(&o.Wait, "wait", "w", false, "waits for the Terraform Operator to destroy the resources and then removes their Terraform state")
+	cmd.Flags().DurationVarP(&o.GracePeriod, "grace-period", "", terraforms.DefaultDeleteGracePeriod, "how long to wait for the operator to destroy the resources before removing their finalizers if --wait is enabled")
+	cmd.Flags().StringVarP(&o.TerraformAPIVersion, "tf-api-version", "", "", "the version of the Terraform Operator API: v1alpha1 (tf.isaaguilar.com) or v1beta1 (tf.galleybytes.com). Detected from the cluster if not specified")
+	return cmd, o
+}
+
+// Run implements the command
+func (o *Options) Run() error {
+	err := o.Validate()
+	if err != nil {
+		return fmt.Errorf("failed to validate: %w", err)
+	}
+
+	ctx := o.GetContext()
+	o.Client = dynkube.DynamicResource(o.DynamicClient, o.Namespace, o.TerraformVersion.Resource)
+
+	names, err := o.findNames(ctx)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		log.Logger().Infof("no test resources found to delete in namespace %s", o.Namespace)
+		return nil
+	}
+
+	o.Deleted = map[string]terraforms.DeletePath{}
+	for _, name := range names {
+		path, err := o.deleteTest(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to delete test %s: %w", name, err)
+		}
+		o.Deleted[name] = path
+	}
+	return nil
+}
+
+// findNames returns the names of the test resources to delete
+func (o *Options) findNames(ctx context.Context) ([]string, error) {
+	if o.Name != "" {
+		_, err := o.Client.Get(ctx, o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s %s in namespace %s: %w", terraforms.TerraformKind, o.Name, o.Namespace, err)
+		}
+		return []string{o.Name}, nil
+	}
+
+	selector := dynkube.ToSelector(o.Labels)
+	list, err := o.Client.List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s resources in namespace %s with selector %s: %w", terraforms.TerraformKind, o.Namespace, selector, err)
+	}
+	var answer []string
+	for i := range list.Items {
+		answer = append(answer, list.Items[i].GetName())
+	}
+	return answer, nil
+}
+
+// deleteTest deletes the test resource, waiting for it to be destroyed and removing its state if --wait is enabled
+func (o *Options) deleteTest(ctx context.Context, name string) (terraforms.DeletePath, error) {
+	ns := o.Namespace
+	err := terraforms.DeleteActiveTerraformJobs(ctx, o.KubeClient, o.TerraformVersion, ns, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete active Terraform Jobs for namespace %s name %s: %w", ns, name, err)
+	}
+
+	if !o.Wait {
+		err = o.Client.Delete(ctx, name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return terraforms.DeletePathNotFound, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to delete %s %s: %w", terraforms.TerraformKind, name, err)
+		}
+		log.Logger().Infof("deleted %s %s. Its Terraform state is removed by gc once destroyed", terraforms.TerraformKind, info(name))
+		return terraforms.DeletePathDeleted, nil
+	}
+
+	d := &terraforms.Deleter{
+		Client:      o.Client,
+		GracePeriod: o.GracePeriod,
+		PollPeriod:  o.PollPeriod,
+	}
+	path, err := d.Delete(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	log.Logger().Infof("%s %s was removed: %s", terraforms.TerraformKind, info(name), string(path))
+
+	keep, err := o.keepStateReason(ctx, name, path)
+	if err != nil {
+		return "", err
+	}
+	if keep != "" {
+		log.Logger().Infof("keeping the Terraform state of %s %s as %s. It is removed by gc once it is orphaned", terraforms.TerraformKind, info(name), keep)
+		return path, nil
+	}
+
+	err = o.deleteState(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// keepStateReason returns the reason the state of the deleted Terraform resource must be kept or an empty string
+// if it is safe to delete. The state is kept if the resource may not have been destroyed because its finalizers were
+// removed or if its destroy Job is still running
+func (o *Options) keepStateReason(ctx context.Context, name string, path terraforms.DeletePath) (string, error) {
+	if path == terraforms.DeletePathFinalizersRemoved {
+		return "its finalizers were removed so it may not have been destroyed", nil
+	}
+	jobList, err := terraforms.ListTerraformJobs(ctx, o.KubeClient, o.TerraformVersion, o.Namespace, name)
+	if err != nil {
+		return "", err
+	}
+	for i := range jobList {
+		job := &jobList[i]
+		if terraforms.IsActiveTerraformJob(o.TerraformVersion, job, name) {
+			return fmt.Sprintf("Job %s is still running", job.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// deleteState deletes the Terraform state Secrets and Leases of the given Terraform resource
+func (o *Options) deleteState(ctx context.Context, name string) error {
+	ns := o.Namespace
+	listOptions := metav1.ListOptions{
+		LabelSelector: terraforms.StateSelector,
+	}
+
+	secretInterface := o.KubeClient.CoreV1().Secrets(ns)
+	secretList, err := secretInterface.List(ctx, listOptions)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to list Secrets in namespace %s with selector %s: %w", ns, terraforms.StateSelector, err)
+	}
+	if secretList != nil {
+		for i := range secretList.Items {
+			r := &secretList.Items[i]
+			if terraforms.StateOwnerName(r) != name {
+				continue
+			}
+			err = secretInterface.Delete(ctx, r.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete Secret %s in namespace %s: %w", r.Name, ns, err)
+			}
+			log.Logger().Infof("deleted Terraform state Secret %s", info(r.Name))
+		}
+	}
+
+	leaseInterface := o.KubeClient.CoordinationV1().Leases(ns)
+	leaseList, err := leaseInterface.List(ctx, listOptions)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to list Leases in namespace %s with selector %s: %w", ns, terraforms.StateSelector, err)
+	}
+	if leaseList != nil {
+		for i := range leaseList.Items {
+			r := &leaseList.Items[i]
+			if terraforms.StateOwnerName(r) != name {
+				continue
+			}
+			err = leaseInterface.Delete(ctx, r.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete Lease %s in namespace %s: %w", r.Name, ns, err)
+			}
+			log.Logger().Infof("deleted Terraform state Lease %s", info(r.Name))
+		}
+	}
+	return nil
+}
+
+// Validate validates the options, builds the selector labels and lazily creates the clients
+func (o *Options) Validate() error {
+	if o.Name == "" {
+		o.Labels = terraforms.PipelineLabels(&o.Options)
+		if len(o.Labels) == 1 {
+			return options.MissingOption("name")
+		}
+	}
+
+	var err error
+	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+	o.DynamicClient, err = kube.LazyCreateDynamicClient(o.DynamicClient)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	if o.TerraformVersion == nil {
+		o.TerraformVersion, err = terraforms.ResolveVersion(o.TerraformAPIVersion, o.KubeClient.Discovery())
+		if err != nil {
+			return options.InvalidOptionf("tf-api-version", o.TerraformAPIVersion, "%s", err.Error())
+		}
+	}
+	return nil
+}
+
+// GetContext lazily creates a context if it doesn't exist already
+func (o *Options) GetContext() context.Context {
+	if o.Ctx == nil {
+		o.Ctx = context.TODO()
+	}
+	return o.Ctx
+}
@@ -0,0 +1,226 @@
+package delete_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/delete"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	testResources = []string{
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-456
+    repo: myrepo
+  name: tf-myrepo-pr456-myctx-1
+  namespace: jx
+`,
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-456
+    repo: myrepo
+  name: tf-myrepo-pr456-myctx-2
+  namespace: jx
+`,
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-999
+    repo: myrepo
+  name: tf-myrepo-pr999-myctx-3
+  namespace: jx
+`,
+	}
+)
+
+func TestDeleteByPullRequest(t *testing.T) {
+	ns := "jx"
+	stateLabels := map[string]string{"tfstate": "true"}
+
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+
+	_, o := delete.NewCmdDelete()
+	o.Namespace = ns
+	o.RepoOwner = "myowner"
+	o.RepoName = "myrepo"
+	o.Context = "myctx"
+	o.PullRequestNumber = 456
+	o.DynamicClient = fakeDynClient
+	o.KubeClient = fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-myrepo-pr456-myctx-1-state", Namespace: ns, Labels: stateLabels},
+		},
+	)
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run delete command")
+
+	ctx := o.GetContext()
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	require.Len(t, list.Items, 1, "should have removed the PR resources")
+	assert.Equal(t, "tf-myrepo-pr999-myctx-3", list.Items[0].GetName(), "remaining Terraform")
+	assert.Len(t, o.Deleted, 2, "deleted tests")
+
+	secretList, err := o.KubeClient.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Secrets")
+	assert.Len(t, secretList.Items, 1, "should not remove the state without --wait")
+}
+
+func TestDeleteByNameAndWait(t *testing.T) {
+	ns := "jx"
+	name := "tf-myrepo-pr999-myctx-3"
+	stateLabels := map[string]string{"tfstate": "true"}
+
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+
+	_, o := delete.NewCmdDelete()
+	o.Namespace = ns
+	o.Name = name
+	o.Wait = true
+	o.GracePeriod = 20 * time.Millisecond
+	o.PollPeriod = time.Millisecond
+	o.DynamicClient = fakeDynClient
+	o.KubeClient = fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-" + name + "-state", Namespace: ns, Labels: stateLabels},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "lock-tfstate-default-" + name + "-state", Namespace: ns, Labels: stateLabels},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-myrepo-pr456-myctx-1-state", Namespace: ns, Labels: stateLabels},
+		},
+	)
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run delete command")
+	assert.Equal(t, map[string]terraforms.DeletePath{name: terraforms.DeletePathDeleted}, o.Deleted, "deleted tests")
+
+	ctx := o.GetContext()
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	assert.Len(t, list.Items, 2, "should only remove the named resource")
+
+	secretList, err := o.KubeClient.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Secrets")
+	require.Len(t, secretList.Items, 1, "should have removed the state Secret")
+	assert.Equal(t, "tfstate-default-tf-myrepo-pr456-myctx-1-state", secretList.Items[0].Name, "remaining state Secret")
+
+	leaseList, err := o.KubeClient.CoordinationV1().Leases(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Leases")
+	assert.Empty(t, leaseList.Items, "should have removed the state Lease")
+}
+
+func TestDeleteWaitKeepsState(t *testing.T) {
+	ns := "jx"
+	name := "tf-myrepo-pr999-myctx-3"
+	stateName := "tfstate-default-" + name + "-state"
+	stateLabels := map[string]string{"tfstate": "true"}
+
+	testCases := []struct {
+		name       string
+		stuck      bool
+		destroyJob bool
+		expected   terraforms.DeletePath
+	}{
+		{
+			name:     "finalizers-removed",
+			stuck:    true,
+			expected: terraforms.DeletePathFinalizersRemoved,
+		},
+		{
+			name:       "destroy-job-active",
+			destroyJob: true,
+			expected:   terraforms.DeletePathDeleted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dynObjects := tftests.ParseUnstructureds(t, nil, testResources)
+			fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+			kubeClient := fake.NewSimpleClientset(
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: stateName, Namespace: ns, Labels: stateLabels},
+				},
+			)
+			if tc.stuck {
+				// simulate the operator never removing its finalizer
+				fakeDynClient.PrependReactor("delete", "terraforms", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, nil
+				})
+			}
+			if tc.destroyJob {
+				// simulate the operator starting a destroy Job when the resource is deleted
+				fakeDynClient.PrependReactor("delete", "terraforms", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+					job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name + "-destroy", Namespace: ns}}
+					_, err := kubeClient.BatchV1().Jobs(ns).Create(context.TODO(), job, metav1.CreateOptions{})
+					assert.NoError(t, err, "failed to create destroy Job")
+					return false, nil, nil
+				})
+			}
+
+			_, o := delete.NewCmdDelete()
+			o.Namespace = ns
+			o.Name = name
+			o.Wait = true
+			o.GracePeriod = 20 * time.Millisecond
+			o.PollPeriod = time.Millisecond
+			o.DynamicClient = fakeDynClient
+			o.KubeClient = kubeClient
+
+			err := o.Run()
+			require.NoError(t, err, "failed to run delete command")
+			assert.Equal(t, map[string]terraforms.DeletePath{name: tc.expected}, o.Deleted, "deleted tests")
+
+			secretList, err := kubeClient.CoreV1().Secrets(ns).List(o.GetContext(), metav1.ListOptions{})
+			require.NoError(t, err, "failed to list Secrets")
+			require.Len(t, secretList.Items, 1, "should have kept the state Secret")
+			assert.Equal(t, stateName, secretList.Items[0].Name, "state Secret")
+		})
+	}
+}
+
+func TestDeleteNeedsNameOrContext(t *testing.T) {
+	_, o := delete.NewCmdDelete()
+	o.Options.RepoName = ""
+	o.Options.RepoOwner = ""
+	o.Options.Context = ""
+	o.Options.PullRequestNumber = 0
+	o.Namespace = "jx"
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err := o.Run()
+	require.Error(t, err, "should fail without a name or pipeline context")
+}
@@ -0,0 +1,197 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+func newNamespaceCollector(o *Options) Collector {
+	namespaceInterface := o.KubeClient.CoreV1().Namespaces()
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		var answer []*Candidate
+		err := dynkube.ListPages(ctx, namespaceInterface.List, o.listOptions(o.Selector), func(list *corev1.NamespaceList) error {
+			for i := range list.Items {
+				r := &list.Items[i]
+				c, err := o.ownedCandidate(ctx, "Namespace", r)
+				if err != nil {
+					return err
+				}
+				if c.Keep == "" {
+					switch {
+					case r.Name == o.Namespace:
+						c.Keep = "it is the namespace of the test resources"
+					case r.Status.Phase == corev1.NamespaceTerminating:
+						c.Keep = "it is already terminating"
+					}
+				}
+				answer = append(answer, c)
+			}
+			return nil
+		})
+		if apierrors.IsForbidden(err) {
+			log.Logger().Warnf("not garbage collecting Namespaces as listing them is forbidden: %s", err.Error())
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Namespaces with selector %s: %w", o.Selector, err)
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		err := namespaceInterface.Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Namespace %s: %w", c.Name, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorNamespace, list, deleteFn)
+}
+
+func newClusterResourceCollector(o *Options) Collector {
+	// the resource of each candidate keyed by kind and name
+	resources := map[string]schema.GroupVersionResource{}
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		apiResources, err := clusterResources(o.KubeClient.Discovery())
+		if err != nil {
+			return nil, err
+		}
+
+		var answer []*Candidate
+		for _, ar := range apiResources {
+			gvr := schema.GroupVersionResource{Group: ar.Group, Version: ar.Version, Resource: ar.Name}
+			err = dynkube.ListPages(ctx, o.DynamicClient.Resource(gvr).List, o.listOptions(o.Selector), func(list *unstructured.UnstructuredList) error {
+				for i := range list.Items {
+					r := &list.Items[i]
+					c, err := o.ownedCandidate(ctx, ar.Kind, r)
+					if err != nil {
+						return err
+					}
+					resources[ar.Kind+"/"+r.GetName()] = gvr
+					answer = append(answer, c)
+				}
+				return nil
+			})
+			if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) || apierrors.IsMethodNotSupported(err) {
+				log.Logger().Debugf("cannot list %s: %s", gvr.String(), err.Error())
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s: %w", gvr.String(), err)
+			}
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		gvr, ok := resources[c.Kind+"/"+c.Name]
+		if !ok {
+			return fmt.Errorf("no resource found for %s %s", c.Kind, c.Name)
+		}
+		err := o.DynamicClient.Resource(gvr).Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %w", c.Kind, c.Name, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorClusterResource, list, deleteFn)
+}
+
+// clusterResources returns the cluster scoped resources of the preferred version of each API group other than
+// namespaces which can be listed and deleted
+func clusterResources(discoveryClient discovery.DiscoveryInterface) ([]metav1.APIResource, error) {
+	groups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the API groups: %w", err)
+	}
+	var answer []metav1.APIResource
+	for i := range groups.Groups {
+		gv := groups.Groups[i].PreferredVersion
+		list, err := discoveryClient.ServerResourcesForGroupVersion(gv.GroupVersion)
+		if err != nil {
+			log.Logger().Warnf("failed to discover the API resources of %s: %s", gv.GroupVersion, err.Error())
+			continue
+		}
+		for j := range list.APIResources {
+			ar := list.APIResources[j]
+			if ar.Namespaced || strings.Contains(ar.Name, "/") || (groups.Groups[i].Name == "" && ar.Kind == "Namespace") {
+				continue
+			}
+			if !hasVerbs(ar.Verbs, "list", "delete") {
+				continue
+			}
+			ar.Group = groups.Groups[i].Name
+			ar.Version = gv.Version
+			answer = append(answer, ar)
+		}
+	}
+	return answer, nil
+}
+
+// ownedCandidate creates the candidate for an object created by a test keeping it while its owning test resource
+// still exists or if the owner cannot be checked. Otherwise the object is removed once it is older than the duration
+func (o *Options) ownedCandidate(ctx context.Context, kind string, obj metav1.Object) (*Candidate, error) {
+	c := newCandidate(kind, obj)
+	owner := terraforms.ParseOwnerAnnotation(obj.GetAnnotations())
+	if owner == nil || c.Keep != "" {
+		return c, nil
+	}
+	c.Owner = owner.String()
+	if owner.Kind == "" || owner.APIVersion == "" {
+		c.Keep = fmt.Sprintf("the kind of the owner %s is unknown", c.Owner)
+		return c, nil
+	}
+	gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	gvr, namespaced, err := dynkube.ResourceMapping(o.RESTMapper, gvk)
+	if err != nil {
+		log.Logger().Debugf("cannot resolve the owner of %s %s: %s", kind, c.Name, err.Error())
+		c.Keep = fmt.Sprintf("the kind %s of the owner %s cannot be resolved", owner.Kind, c.Owner)
+		return c, nil
+	}
+	ns := ""
+	if namespaced {
+		ns = owner.Namespace
+		if ns == "" {
+			ns = o.Namespace
+		}
+	}
+	_, err = dynkube.DynamicResource(o.DynamicClient, ns, gvr).Get(ctx, owner.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		c.Keep = fmt.Sprintf("%s %s still exists", owner.Kind, c.Owner)
+	case apierrors.IsNotFound(err):
+		c.Orphaned = true
+		log.Logger().Infof("%s %s is orphaned as %s %s no longer exists", kind, info(c.Name), owner.Kind, c.Owner)
+	case apierrors.IsForbidden(err):
+		c.Keep = fmt.Sprintf("not allowed to get the owner %s %s", owner.Kind, c.Owner)
+	default:
+		return nil, fmt.Errorf("failed to get %s %s: %w", owner.Kind, c.Owner, err)
+	}
+	return c, nil
+}
+
+func hasVerbs(verbs metav1.Verbs, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range verbs {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,285 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/stringhelpers"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Candidate a resource which may be garbage collected
+type Candidate struct {
+	// Kind the kind of the resource
+	Kind string
+
+	// Namespace the namespace, organisation or other scope of the resource if any
+	Namespace string
+
+	// Name the name of the resource
+	Name string
+
+	// Created when the resource was created
+	Created time.Time
+
+	// Keep if not empty the reason the candidate must be kept whatever its age
+	Keep string
+
+	// Owner the name of the resource which owns the candidate if known
+	Owner string
+
+	// Orphaned true if the resource which owned the candidate no longer exists
+	Orphaned bool
+
+	// Result optionally set by the collector when deleting the candidate to record how it was deleted
+	Result string
+}
+
+// Collector lists and deletes the candidates for garbage collection of a particular kind of resource
+type Collector interface {
+	// Name returns the unique name of the collector used by the --include and --exclude flags
+	Name() string
+
+	// List lists the candidates for garbage collection. If only some candidates could be listed the candidates
+	// are returned along with the error so that they are still garbage collected
+	List(ctx context.Context) ([]*Candidate, error)
+
+	// Delete deletes the given candidate
+	Delete(ctx context.Context, candidate *Candidate) error
+}
+
+// KeepError is returned by Collector.Delete if the candidate is kept after all, for example because it changed
+// after it was listed
+type KeepError struct {
+	// Reason why the candidate is kept
+	Reason string
+}
+
+func (e *KeepError) Error() string {
+	return "kept as " + e.Reason
+}
+
+// CollectorFactory creates a collector for the given command options
+type CollectorFactory func(o *Options) Collector
+
+type registration struct {
+	name     string
+	factory  CollectorFactory
+	optional bool
+}
+
+// registry the registered collectors in the order they run
+var registry []registration
+
+// RegisterCollector registers a collector with the given name. Collectors run in the order they are registered.
+// The returned function unregisters the collector again restoring any collector it replaced
+func RegisterCollector(name string, factory CollectorFactory) func() {
+	return register(registration{name: name, factory: factory})
+}
+
+// RegisterOptionalCollector registers a collector which only runs if it is named by the --enable or --include flags.
+// This is used for collectors which need more permissions than the default collectors
+func RegisterOptionalCollector(name string, factory CollectorFactory) func() {
+	return register(registration{name: name, factory: factory, optional: true})
+}
+
+func register(r registration) func() {
+	for i := range registry {
+		if registry[i].name == r.name {
+			previous := registry[i]
+			registry[i] = r
+			return func() {
+				replace(r.name, &previous)
+			}
+		}
+	}
+	registry = append(registry, r)
+	return func() {
+		replace(r.name, nil)
+	}
+}
+
+// replace replaces the registration with the given name or removes it if the replacement is nil
+func replace(name string, r *registration) {
+	for i := range registry {
+		if registry[i].name == name {
+			if r != nil {
+				registry[i] = *r
+			} else {
+				registry = append(registry[:i], registry[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// CollectorNames returns the names of the registered collectors
+func CollectorNames() []string {
+	var answer []string
+	for _, r := range registry {
+		answer = append(answer, r.name)
+	}
+	return answer
+}
+
+// OptionalCollectorNames returns the names of the registered collectors which only run if they are enabled
+func OptionalCollectorNames() []string {
+	var answer []string
+	for _, r := range registry {
+		if r.optional {
+			answer = append(answer, r.name)
+		}
+	}
+	return answer
+}
+
+// NewCollector creates a collector from the given list and delete functions
+func NewCollector(name string, list func(ctx context.Context) ([]*Candidate, error), deleteFn func(ctx context.Context, candidate *Candidate) error) Collector {
+	return &funcCollector{
+		name:     name,
+		list:     list,
+		deleteFn: deleteFn,
+	}
+}
+
+type funcCollector struct {
+	name     string
+	list     func(ctx context.Context) ([]*Candidate, error)
+	deleteFn func(ctx context.Context, candidate *Candidate) error
+}
+
+func (c *funcCollector) Name() string {
+	return c.name
+}
+
+func (c *funcCollector) List(ctx context.Context) ([]*Candidate, error) {
+	return c.list(ctx)
+}
+
+func (c *funcCollector) Delete(ctx context.Context, candidate *Candidate) error {
+	return c.deleteFn(ctx, candidate)
+}
+
+// Collectors creates the registered collectors filtered by the --include, --enable and --exclude flags
+func (o *Options) Collectors() ([]Collector, error) {
+	names := CollectorNames()
+	for _, name := range append(append(append([]string{}, o.Include...), o.Enable...), o.Exclude...) {
+		if stringhelpers.StringArrayIndex(names, name) < 0 {
+			return nil, options.InvalidOptionf("include", name, "available collectors are %v", names)
+		}
+	}
+
+	var answer []Collector
+	for _, r := range registry {
+		if len(o.Include) > 0 && stringhelpers.StringArrayIndex(o.Include, r.name) < 0 {
+			continue
+		}
+		if len(o.Include) == 0 && r.optional && stringhelpers.StringArrayIndex(o.Enable, r.name) < 0 {
+			continue
+		}
+		if stringhelpers.StringArrayIndex(o.Exclude, r.name) >= 0 {
+			continue
+		}
+		answer = append(answer, r.factory(o))
+	}
+	return answer, nil
+}
+
+// deletion a candidate to be deleted along with its item in the plan
+type deletion struct {
+	candidate *Candidate
+	item      *PlanItem
+}
+
+// collect garbage collects the candidates of the collector which are older than the created time
+func (o *Options) collect(ctx context.Context, c Collector, createdTime *metav1.Time) error {
+	candidates, listErr := c.List(ctx)
+	if listErr != nil {
+		listErr = fmt.Errorf("failed to list candidates: %w", listErr)
+		if len(candidates) == 0 {
+			return listErr
+		}
+	}
+	var deletions []*deletion
+	for _, candidate := range candidates {
+		kind := candidate.Kind
+		name := candidate.Name
+		item := &PlanItem{
+			Collector: c.Name(),
+			Kind:      kind,
+			Namespace: candidate.Namespace,
+			Name:      name,
+			Created:   candidate.Created,
+			Owner:     candidate.Owner,
+			Orphaned:  candidate.Orphaned,
+		}
+		if candidate.Keep != "" {
+			o.planKeep(item, candidate.Keep)
+			log.Logger().Debugf("not removing %s %s: %s", kind, name, candidate.Keep)
+			continue
+		}
+		if !o.planDeletion(item, createdTime) {
+			log.Logger().Debugf("not removing %s %s as it was created at %s", kind, name, candidate.Created.String())
+			continue
+		}
+		if o.DryRun {
+			continue
+		}
+		deletions = append(deletions, &deletion{candidate: candidate, item: item})
+	}
+	o.deleteCandidates(ctx, c, deletions)
+	return listErr
+}
+
+// deleteCandidates deletes the candidates using a pool of --concurrency workers which start at most --delete-rate
+// deletions a second. A candidate which fails to be deleted is marked as failed in the plan without stopping
+// the other candidates being deleted
+func (o *Options) deleteCandidates(ctx context.Context, c Collector, deletions []*deletion) {
+	work := make(chan *deletion)
+	wg := sync.WaitGroup{}
+	workers := min(o.Concurrency, len(deletions))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				o.deleteCandidate(ctx, c, d)
+			}
+		}()
+	}
+	for _, d := range deletions {
+		work <- d
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (o *Options) deleteCandidate(ctx context.Context, c Collector, d *deletion) {
+	kind := d.candidate.Kind
+	name := d.candidate.Name
+	err := o.rateLimiter.Wait(ctx)
+	if err == nil {
+		err = c.Delete(ctx, d.candidate)
+	}
+	var keepErr *KeepError
+	if errors.As(err, &keepErr) {
+		d.item.Action = ActionKeep
+		d.item.Reason = keepErr.Reason
+		log.Logger().Infof("not deleting %s %s as %s", kind, info(name), keepErr.Reason)
+		return
+	}
+	if err != nil {
+		d.item.Result = ResultFailed
+		d.item.Error = fmt.Sprintf("failed to delete %s %s: %s", kind, name, err.Error())
+		log.Logger().Warnf("%s", d.item.Error)
+		return
+	}
+	d.item.Result = d.candidate.Result
+	log.Logger().Infof("deleted %s %s since it was created at: %s", kind, info(name), d.candidate.Created.String())
+}
@@ -0,0 +1,336 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/locks"
+	"github.com/jenkins-x-plugins/jx-test/pkg/policy"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/jobs"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// CollectorTerraform the name of the collector of Terraform resources
+	CollectorTerraform = "terraform"
+
+	// CollectorLease the name of the collector of Terraform state Leases
+	CollectorLease = "lease"
+
+	// CollectorLock the name of the collector of the expired lock Leases of tests
+	CollectorLock = "lock"
+
+	// CollectorTerraformState the name of the collector of Terraform state Secrets
+	CollectorTerraformState = "terraform-state"
+
+	// CollectorTerraformConfigMap the name of the collector of Terraform version ConfigMaps
+	CollectorTerraformConfigMap = "terraform-configmap"
+
+	// CollectorNamespace the name of the collector of namespaces created by tests
+	CollectorNamespace = "namespace"
+
+	// CollectorClusterResource the name of the collector of cluster scoped resources created by tests
+	CollectorClusterResource = "cluster-resource"
+
+	// CollectorRepository the name of the collector of GitHub repositories
+	CollectorRepository = "repository"
+)
+
+func init() {
+	RegisterCollector(CollectorTerraform, newTerraformCollector)
+	RegisterCollector(CollectorLease, newLeaseCollector)
+	RegisterCollector(CollectorLock, newLockCollector)
+	RegisterCollector(CollectorTerraformState, newTerraformStateCollector)
+	RegisterCollector(CollectorTerraformConfigMap, newTerraformConfigMapCollector)
+	RegisterOptionalCollector(CollectorNamespace, newNamespaceCollector)
+	RegisterOptionalCollector(CollectorClusterResource, newClusterResourceCollector)
+	RegisterCollector(CollectorRepository, newRepositoryCollector)
+}
+
+// newCandidate creates a candidate for the resource applying the keep policy
+func newCandidate(kind string, obj metav1.Object) *Candidate {
+	c := &Candidate{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Created:   obj.GetCreationTimestamp().Time,
+	}
+	decision := policy.Evaluate(obj, time.Now())
+	if decision.Keep {
+		c.Keep = decision.Reason
+	}
+	return c
+}
+
+func newTerraformCollector(o *Options) Collector {
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		var answer []*Candidate
+		err := dynkube.ListPages(ctx, o.Client.List, o.listOptions(o.Selector), func(list *unstructured.UnstructuredList) error {
+			for i := range list.Items {
+				answer = append(answer, newCandidate(terraforms.TerraformKind, &list.Items[i]))
+			}
+			return nil
+		})
+		if err != nil && apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("could not find resources for : %w", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s resources in namespace %s with selector %s: %w", terraforms.TerraformKind, o.Namespace, o.Selector, err)
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		path, err := o.deleteTerraform(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		c.Result = string(path)
+		return nil
+	}
+	return NewCollector(CollectorTerraform, list, deleteFn)
+}
+
+func newLeaseCollector(o *Options) Collector {
+	leaseInterface := o.KubeClient.CoordinationV1().Leases(o.Namespace)
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		s := &stateOwners{}
+		var answer []*Candidate
+		err := dynkube.ListPages(ctx, leaseInterface.List, o.listOptions(terraforms.StateSelector), func(list *coordinationv1.LeaseList) error {
+			for i := range list.Items {
+				c, err := o.stateCandidate(ctx, s, "Lease", &list.Items[i])
+				if err != nil {
+					return err
+				}
+				answer = append(answer, c)
+			}
+			return nil
+		})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Leases in namespace %s with selector %s: %w", o.Namespace, terraforms.StateSelector, err)
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		err := leaseInterface.Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete Lease %s in namespace %s: %w", c.Name, o.Namespace, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorLease, list, deleteFn)
+}
+
+func newLockCollector(o *Options) Collector {
+	leaseInterface := o.KubeClient.CoordinationV1().Leases(o.Namespace)
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		var answer []*Candidate
+		now := time.Now()
+		err := dynkube.ListPages(ctx, leaseInterface.List, o.listOptions(o.Selector), func(list *coordinationv1.LeaseList) error {
+			for i := range list.Items {
+				r := &list.Items[i]
+				if !locks.IsLockLease(r) {
+					continue
+				}
+				c := newCandidate("Lease", r)
+				if c.Keep == "" && !locks.IsExpired(r, now) {
+					c.Keep = fmt.Sprintf("it is held by %s", locks.HolderDescription(r))
+				}
+				answer = append(answer, c)
+			}
+			return nil
+		})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Leases in namespace %s with selector %s: %w", o.Namespace, o.Selector, err)
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		// lets check the lock has not been taken since it was listed
+		lease, err := leaseInterface.Get(ctx, c.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get Lease %s in namespace %s: %w", c.Name, o.Namespace, err)
+		}
+		if !locks.IsExpired(lease, time.Now()) {
+			return &KeepError{Reason: fmt.Sprintf("it was taken by %s", locks.HolderDescription(lease))}
+		}
+		resourceVersion := lease.ResourceVersion
+		err = leaseInterface.Delete(ctx, c.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion},
+		})
+		if apierrors.IsConflict(err) {
+			return &KeepError{Reason: "it was taken by another build"}
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Lease %s in namespace %s: %w", c.Name, o.Namespace, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorLock, list, deleteFn)
+}
+
+func newTerraformStateCollector(o *Options) Collector {
+	secretInterface := o.KubeClient.CoreV1().Secrets(o.Namespace)
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		s := &stateOwners{}
+		var answer []*Candidate
+		err := dynkube.ListPages(ctx, secretInterface.List, o.listOptions(terraforms.StateSelector), func(list *corev1.SecretList) error {
+			for i := range list.Items {
+				c, err := o.stateCandidate(ctx, s, "Secret", &list.Items[i])
+				if err != nil {
+					return err
+				}
+				answer = append(answer, c)
+			}
+			return nil
+		})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Secrets in namespace %s with selector %s: %w", o.Namespace, terraforms.StateSelector, err)
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		err := secretInterface.Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete Secret %s in namespace %s: %w", c.Name, o.Namespace, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorTerraformState, list, deleteFn)
+}
+
+// stateOwners the Terraform resources and unfinished Jobs which may own Terraform state. They are loaded lazily
+// the first time some state is found
+type stateOwners struct {
+	loaded         bool
+	terraformNames map[string]bool
+	activeJobs     []*batchv1.Job
+}
+
+// loadStateOwners pages through the Terraform resources and Jobs in the namespace remembering the names of the
+// Terraform resources and the Jobs which have not finished
+func (o *Options) loadStateOwners(ctx context.Context, s *stateOwners) error {
+	if s.loaded {
+		return nil
+	}
+	s.terraformNames = map[string]bool{}
+	err := dynkube.ListPages(ctx, o.Client.List, o.listOptions(""), func(list *unstructured.UnstructuredList) error {
+		for i := range list.Items {
+			s.terraformNames[list.Items[i].GetName()] = true
+		}
+		return nil
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to list %s resources in namespace %s: %w", terraforms.TerraformKind, o.Namespace, err)
+	}
+	err = dynkube.ListPages(ctx, o.KubeClient.BatchV1().Jobs(o.Namespace).List, o.listOptions(""), func(list *batchv1.JobList) error {
+		for i := range list.Items {
+			job := &list.Items[i]
+			if !jobs.IsJobFinished(job) {
+				s.activeJobs = append(s.activeJobs, job)
+			}
+		}
+		return nil
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to list Jobs in namespace %s: %w", o.Namespace, err)
+	}
+	s.loaded = true
+	return nil
+}
+
+// stateCandidate creates the candidate for a Terraform state object keeping any state whose Terraform
+// resource still exists or whose apply or destroy Job is still running. Any other state is orphaned
+func (o *Options) stateCandidate(ctx context.Context, s *stateOwners, kind string, obj metav1.Object) (*Candidate, error) {
+	err := o.loadStateOwners(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCandidate(kind, obj)
+	owner := terraforms.StateOwnerName(obj)
+	c.Owner = owner
+	if c.Keep != "" {
+		return c, nil
+	}
+	if owner != "" && s.terraformNames[owner] {
+		c.Keep = fmt.Sprintf("%s %s still exists", terraforms.TerraformKind, owner)
+		return c, nil
+	}
+	if owner != "" {
+		for _, job := range s.activeJobs {
+			if terraforms.IsActiveTerraformJob(o.TerraformVersion, job, owner) {
+				c.Keep = fmt.Sprintf("Job %s of %s %s is still running", job.Name, terraforms.TerraformKind, owner)
+				return c, nil
+			}
+		}
+	}
+	c.Orphaned = true
+	if owner == "" {
+		log.Logger().Warnf("could not find the %s owning the state %s %s", terraforms.TerraformKind, kind, info(c.Name))
+	} else {
+		log.Logger().Infof("state %s %s is orphaned as %s %s no longer exists", kind, info(c.Name), terraforms.TerraformKind, owner)
+	}
+	return c, nil
+}
+
+func newTerraformConfigMapCollector(o *Options) Collector {
+	if o.TerraformConfigMapFilter.IsEmpty() {
+		o.TerraformConfigMapFilter.Prefixes = []string{defaultTerraformConfigMapPrefix}
+	}
+	filter := &o.TerraformConfigMapFilter
+	configMapInterface := o.KubeClient.CoreV1().ConfigMaps(o.Namespace)
+	list := func(ctx context.Context) ([]*Candidate, error) {
+		var answer []*Candidate
+		found := map[string]bool{}
+		for _, selector := range filter.ListSelectors() {
+			err := dynkube.ListPages(ctx, configMapInterface.List, o.listOptions(selector), func(list *corev1.ConfigMapList) error {
+				for i := range list.Items {
+					r := &list.Items[i]
+					if found[r.Name] || !filter.Matches(r.Name) {
+						continue
+					}
+					found[r.Name] = true
+					answer = append(answer, newCandidate("ConfigMap", r))
+				}
+				return nil
+			})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list ConfigMaps in namespace %s with selector %s: %w", o.Namespace, selector, err)
+			}
+		}
+		return answer, nil
+	}
+	deleteFn := func(ctx context.Context, c *Candidate) error {
+		err := configMapInterface.Delete(ctx, c.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete ConfigMap %s in namespace %s: %w", c.Name, o.Namespace, err)
+		}
+		return nil
+	}
+	return NewCollector(CollectorTerraformConfigMap, list, deleteFn)
+}
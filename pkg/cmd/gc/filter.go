@@ -0,0 +1,40 @@
+package gc
+
+import "strings"
+
+// Filter selects the resources of a collector using label selectors and name prefixes
+type Filter struct {
+	// Selectors the label selectors used to list the resources on the server. If there are several selectors
+	// a resource matching any of them is selected
+	Selectors []string
+
+	// Prefixes if specified only resources whose name has one of the prefixes are selected
+	Prefixes []string
+}
+
+// IsEmpty returns true if there are no selectors or prefixes
+func (f *Filter) IsEmpty() bool {
+	return len(f.Selectors) == 0 && len(f.Prefixes) == 0
+}
+
+// ListSelectors returns the label selectors to list the resources with. There is always at least one selector
+// which is empty if all resources should be listed
+func (f *Filter) ListSelectors() []string {
+	if len(f.Selectors) == 0 {
+		return []string{""}
+	}
+	return f.Selectors
+}
+
+// Matches returns true if the resource with the given name has one of the prefixes or there are no prefixes
+func (f *Filter) Matches(name string) bool {
+	if len(f.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
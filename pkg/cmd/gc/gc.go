@@ -2,17 +2,16 @@ package gc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/bradleyfalzon/ghinstallation/v2"
-	"github.com/google/go-github/v69/github"
-	"net/http"
+	"io"
+	"os"
+	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
 	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
-	"github.com/jenkins-x/jx-helpers/v3/pkg/cmdrunner"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
 	"k8s.io/client-go/kubernetes"
 
@@ -20,12 +19,14 @@ import (
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/helper"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/templates"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/kube"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
 	"github.com/jenkins-x/jx-logging/v3/pkg/log"
 
 	"github.com/spf13/cobra"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 var (
@@ -37,26 +38,55 @@ var (
 
 	cmdExample = templates.Examples(`
 		%s gc
-	`)
 
-	terraformStateSelector = "tfstate=true"
+		# view what would be garbage collected
+		%s gc --dry-run
+	`)
 
 	defaultTerraformConfigMapPrefix = "tf-jx3-versions-"
+
+	defaultConcurrency = 4
+
+	defaultDeleteRate float32 = 5
+
+	defaultDeleteBurst = 10
 )
 
 // Options the options for the command
 type Options struct {
 	Selector                 string
 	Namespace                string
-	TerraformConfigMapPrefix string
+	TerraformConfigMapFilter Filter
+	PageSize                 int64
+	Concurrency              int
+	DeleteRate               float32
+	DeleteBurst              int
 	Duration                 time.Duration
 	KubeClient               kubernetes.Interface
 	DynamicClient            dynamic.Interface
 	Ctx                      context.Context
 	Client                   dynamic.ResourceInterface
-	CommandRunner            cmdrunner.CommandRunner
+	TerraformAPIVersion      string
+	TerraformVersion         *terraforms.Version
+	RESTMapper               meta.RESTMapper
+	DeleteGracePeriod        time.Duration
+	DeletePollPeriod         time.Duration
 	AppID                    int64
 	AppCertificateFile       string
+	DryRun                   bool
+	OutputFormat             string
+	Out                      io.Writer
+	Plan                     *Plan
+	Include                  []string
+	Exclude                  []string
+	Enable                   []string
+	GitHubURL                string
+	RepositoryRegex          string
+	RepositoryTopic          string
+	RepositoryKeep           []string
+
+	repositoryRegex *regexp.Regexp
+	rateLimiter     flowcontrol.RateLimiter
 }
 
 // NewCmdGC creates a command object for the command
@@ -67,7 +97,7 @@ func NewCmdGC() (*cobra.Command, *Options) {
 		Use:     "gc",
 		Short:   "Garbage collects test resources",
 		Long:    cmdLong,
-		Example: fmt.Sprintf(cmdExample, root.BinaryName),
+		Example: fmt.Sprintf(cmdExample, root.BinaryName, root.BinaryName),
 		Run: func(_ *cobra.Command, _ []string) {
 			err := o.Run()
 			helper.CheckErr(err)
@@ -80,10 +110,26 @@ func NewCmdGC() (*cobra.Command, *Options) {
 
 	cmd.Flags().StringVarP(&o.Namespace, "ns", "n", "", "the namespace to query the Terraform resources")
 	cmd.Flags().StringVarP(&o.Selector, "selector", "l", "kind="+terraforms.LabelValueKindTest, "the selector to find the Terraform resources to remove")
-	cmd.Flags().StringVarP(&o.TerraformConfigMapPrefix, "tf-cm-prefix", "t", defaultTerraformConfigMapPrefix, "the ConfigMap name prefix of the Terraform state")
+	cmd.Flags().StringSliceVarP(&o.TerraformConfigMapFilter.Prefixes, "tf-cm-prefix", "t", nil, fmt.Sprintf("the name prefixes of the Terraform version ConfigMaps. Defaults to %s if no --tf-cm-selector is specified", defaultTerraformConfigMapPrefix))
+	cmd.Flags().StringArrayVarP(&o.TerraformConfigMapFilter.Selectors, "tf-cm-selector", "", nil, "the label selector of the Terraform version ConfigMaps. Can be specified multiple times in which case a ConfigMap matching any of the selectors is garbage collected")
+	cmd.Flags().Int64VarP(&o.PageSize, "page-size", "", dynkube.DefaultPageSize, "the maximum number of resources to fetch from the server in each request")
 	cmd.Flags().DurationVarP(&o.Duration, "duration", "d", 2*time.Hour, "The maximum age of a Terraform resource before it is garbage collected")
+	cmd.Flags().StringVarP(&o.TerraformAPIVersion, "tf-api-version", "", "", "the version of the Terraform Operator API: v1alpha1 (tf.isaaguilar.com) or v1beta1 (tf.galleybytes.com). Detected from the cluster if not specified")
+	cmd.Flags().DurationVarP(&o.DeleteGracePeriod, "delete-grace-period", "", terraforms.DefaultDeleteGracePeriod, "how long to wait for the operator to destroy a deleted Terraform resource before removing its finalizers")
 	cmd.Flags().Int64Var(&o.AppID, "app-id", 0, "GitHub App ID used to gc repositories")
 	cmd.Flags().StringVar(&o.AppCertificateFile, "app-certificate-file", "", "Certificate for GitHub App used to gc repositories")
+	cmd.Flags().StringVar(&o.GitHubURL, "github-url", "", "the URL of the GitHub Enterprise server used to gc repositories. Defaults to https://github.com")
+	cmd.Flags().StringVar(&o.RepositoryRegex, "repo-regex", "", "the regular expression of the names of repositories to gc. Either this or --repo-topic must be specified to gc repositories")
+	cmd.Flags().StringVar(&o.RepositoryTopic, "repo-topic", "", "the topic of the repositories to gc. Either this or --repo-regex must be specified to gc repositories")
+	cmd.Flags().StringSliceVar(&o.RepositoryKeep, "repo-keep", nil, "the names (or owner/name) of repositories which must never be garbage collected")
+	cmd.Flags().StringSliceVarP(&o.Include, "include", "", nil, fmt.Sprintf("the names of the collectors to run. Defaults to all collectors except the optional ones: %s", strings.Join(CollectorNames(), ", ")))
+	cmd.Flags().StringSliceVarP(&o.Exclude, "exclude", "", nil, "the names of the collectors to not run")
+	cmd.Flags().StringSliceVarP(&o.Enable, "enable", "", nil, fmt.Sprintf("the names of the optional collectors to run as well as the default collectors. The optional collectors need cluster wide permissions: %s", strings.Join(OptionalCollectorNames(), ", ")))
+	cmd.Flags().IntVarP(&o.Concurrency, "concurrency", "", defaultConcurrency, "the number of resources to delete in parallel")
+	cmd.Flags().Float32VarP(&o.DeleteRate, "delete-rate", "", defaultDeleteRate, "the maximum number of candidate deletions to start each second. This does not limit the other requests to the API server")
+	cmd.Flags().IntVarP(&o.DeleteBurst, "delete-burst", "", defaultDeleteBurst, "the maximum number of candidate deletions to start at once before --delete-rate applies")
+	cmd.Flags().BoolVarP(&o.DryRun, "dry-run", "", false, "reports what would be garbage collected without deleting anything")
+	cmd.Flags().StringVarP(&o.OutputFormat, "output", "o", "", "the output format of the garbage collection plan: table, json or yaml. Defaults to table if --dry-run is enabled")
 	return cmd, o
 }
 
@@ -96,280 +142,164 @@ func (o *Options) Run() error {
 
 	ctx := o.GetContext()
 	ns := o.Namespace
-	gvr := terraforms.TerraformResource
+	gvr := o.TerraformVersion.Resource
 	o.Client = dynkube.DynamicResource(o.DynamicClient, ns, gvr)
 
-	kind := "Terraform"
-
-	// lets delete all the previous resources for this Pull Request and Context
-	list, err := o.Client.List(ctx, metav1.ListOptions{
-		LabelSelector: o.Selector,
-	})
-	if err != nil && apierrors.IsNotFound(err) {
-		return fmt.Errorf("could not find resources for : %w", err)
+	collectors, err := o.Collectors()
+	if err != nil {
+		return fmt.Errorf("failed to create collectors: %w", err)
 	}
 
 	createdBefore := time.Now().Add(o.Duration * -1)
 	createdTime := &metav1.Time{
 		Time: createdBefore,
 	}
-	for _, r := range list.Items {
-		name := r.GetName()
-
-		labels := r.GetLabels()
-		if labels != nil {
-			keep := labels["keep"]
-			if keep != "" {
-				log.Logger().Infof("not removing %s %s as it has a keep label", kind, info(name))
-				continue
-			}
-		}
-
-		created := r.GetCreationTimestamp()
-		if !created.Before(createdTime) {
-			log.Logger().Infof("not removing %s %s as it was created at %s", kind, info(name), created.String())
-			continue
-		}
-
-		err = o.deleteTerraform(ctx, kind, name)
+	// lets run every collector even if some fail so that as much as possible is cleaned up
+	for _, c := range collectors {
+		err = o.collect(ctx, c, createdTime)
 		if err != nil {
-			return fmt.Errorf("failed to delete %s %s: %w", kind, name, err)
+			log.Logger().Warnf("failed to GC %s: %s", c.Name(), err.Error())
+			o.Plan.AddFailure(c.Name(), err)
 		}
-
-		log.Logger().Infof("deleted %s %s since it was created at: %s", kind, info(name), created.String())
 	}
-
-	err = o.gcLeases(ctx, createdTime)
+	summary := o.Plan.Summarise()
+	o.Plan.Summary = summary
+	err = o.printPlan()
 	if err != nil {
-		return fmt.Errorf("failed to GC leases: %w", err)
+		return err
 	}
 
-	err = o.gcTerraformState(ctx, createdTime)
-	if err != nil {
-		return fmt.Errorf("failed to GC terraform state: %w", err)
+	if o.DryRun {
+		log.Logger().Infof("would delete %s resources and keep %s", info(summary.Deleted), info(summary.Skipped))
+	} else {
+		log.Logger().Infof("deleted %s resources, skipped %s and failed to delete %s", info(summary.Deleted), info(summary.Skipped), info(summary.Failed))
 	}
-
-	err = o.gcTerraformConfigMaps(ctx, createdTime)
-	if err != nil {
-		return fmt.Errorf("failed to GC terraform configs: %w", err)
-	}
-	err = o.gcRepositories(ctx, createdTime)
-	if err != nil {
-		return fmt.Errorf("failed to GC test repsitories: %w", err)
+	errs := o.Plan.Errors()
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to garbage collect %d collectors and %d resources: %w", len(o.Plan.Failures), summary.Failed, errors.Join(errs...))
 	}
 	return nil
 }
 
-func (o *Options) deleteTerraform(ctx context.Context, kind, name string) error {
-	ns := o.Namespace
-	err := terraforms.DeleteActiveTerraformJobs(ctx, o.KubeClient, ns, name)
-	if err != nil {
-		return fmt.Errorf("failed to delete active Terraform Jobs for namespace %s name %s: %w", ns, name, err)
-	}
-
-	log.Logger().Infof("deleting %s %s", kind, info(name))
-	var wg sync.WaitGroup
-
-	c := &cmdrunner.Command{
-		Name: "kubectl",
-		Args: []string{"delete", kind, name},
-	}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_, err := o.CommandRunner(c)
-		if err != nil {
-			log.Logger().Errorf("failed to delete %s %s: %s", kind, name, err)
-		}
-	}()
-	time.Sleep(time.Second)
-	c = &cmdrunner.Command{
-		Name: "kubectl",
-		Args: []string{"patch", kind, name, "-p", "{\"metadata\": {\"finalizers\": []}}", "--type=merge"},
+// printPlan prints the plan if running in dry run mode or an output format is specified
+func (o *Options) printPlan() error {
+	if !o.DryRun && o.OutputFormat == "" {
+		return nil
 	}
-	_, err = o.CommandRunner(c)
-	wg.Wait()
+	err := o.Plan.Print(o.Out, o.OutputFormat)
 	if err != nil {
-		return fmt.Errorf("failed to run %s: %w", c.CLI(), err)
+		return fmt.Errorf("failed to print plan: %w", err)
 	}
 	return nil
 }
 
-func (o *Options) Validate() error {
-	if o.CommandRunner == nil {
-		o.CommandRunner = cmdrunner.DefaultCommandRunner
-	}
-	var err error
-	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
-	if err != nil {
-		return fmt.Errorf("failed to create kube client: %w", err)
-	}
-	o.DynamicClient, err = kube.LazyCreateDynamicClient(o.DynamicClient)
-	if err != nil {
-		return fmt.Errorf("failed to craete dynamic client: %w", err)
-	}
-	return nil
+// planKeep adds the item to the plan as being kept for the given reason
+func (o *Options) planKeep(item *PlanItem, reason string) {
+	item.Action = ActionKeep
+	item.Reason = reason
+	o.Plan.Add(item)
 }
 
-// GetContext lazily creates a context if it doesn't exist already
-func (o *Options) GetContext() context.Context {
-	if o.Ctx == nil {
-		o.Ctx = context.TODO()
-	}
-	return o.Ctx
+// planDeletion adds the item to the plan deciding whether it is deleted based on its creation time.
+// Returns true if the item should be deleted
+func (o *Options) planDeletion(item *PlanItem, createdTime *metav1.Time) bool {
+	if !item.Created.Before(createdTime.Time) {
+		o.planKeep(item, fmt.Sprintf("too young: created less than %s ago", o.Duration.String()))
+		return false
+	}
+	item.Action = ActionDelete
+	item.Reason = fmt.Sprintf("created more than %s ago", o.Duration.String())
+	o.Plan.Add(item)
+	return true
 }
 
-func (o *Options) gcLeases(ctx context.Context, createdTime *metav1.Time) error {
-	leaseInterface := o.KubeClient.CoordinationV1().Leases(o.Namespace)
-	list, err := leaseInterface.List(ctx, metav1.ListOptions{
-		LabelSelector: terraformStateSelector,
-	})
-	if apierrors.IsNotFound(err) {
-		err = nil
-	}
+// deleteTerraform deletes the Terraform resource giving the operator a grace period to destroy it
+// before removing its finalizers, returning how it was deleted
+func (o *Options) deleteTerraform(ctx context.Context, name string) (terraforms.DeletePath, error) {
+	ns := o.Namespace
+	err := terraforms.DeleteActiveTerraformJobs(ctx, o.KubeClient, o.TerraformVersion, ns, name)
 	if err != nil {
-		return fmt.Errorf("failed to list Leases in namespace %s with selector %s: %w", o.Namespace, terraformStateSelector, err)
-	}
-	if list == nil {
-		return nil
-	}
-
-	for _, r := range list.Items {
-		created := r.GetCreationTimestamp()
-		if !created.Before(createdTime) {
-			log.Logger().Debugf("not removing Lease %s as it was created at %s", r.Name, created.String())
-			continue
-		}
-		err = leaseInterface.Delete(ctx, r.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to delete Lease %s in namespace %s: %w", r.Name, o.Namespace, err)
-		}
-		log.Logger().Infof("deleted Lease %s", r.Name)
+		return "", fmt.Errorf("failed to delete active Terraform Jobs for namespace %s name %s: %w", ns, name, err)
 	}
-	return nil
-}
 
-func (o *Options) gcTerraformState(ctx context.Context, createdTime *metav1.Time) error {
-	secretInterface := o.KubeClient.CoreV1().Secrets(o.Namespace)
-
-	list, err := secretInterface.List(ctx, metav1.ListOptions{
-		LabelSelector: terraformStateSelector,
-	})
-	if apierrors.IsNotFound(err) {
-		err = nil
+	log.Logger().Infof("deleting %s %s", terraforms.TerraformKind, info(name))
+	d := &terraforms.Deleter{
+		Client:      o.Client,
+		GracePeriod: o.DeleteGracePeriod,
+		PollPeriod:  o.DeletePollPeriod,
 	}
+	path, err := d.Delete(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to list Secrets in namespace %s with selector %s: %w", o.Namespace, terraformStateSelector, err)
-	}
-	if list == nil {
-		return nil
+		return "", err
 	}
-
-	for _, r := range list.Items {
-		created := r.GetCreationTimestamp()
-		if !created.Before(createdTime) {
-			log.Logger().Debugf("not removing Secret %s as it was created at %s", r.Name, created.String())
-			continue
-		}
-		err = secretInterface.Delete(ctx, r.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to delete Secret %s in namespace %s: %w", r.Name, o.Namespace, err)
-		}
-		log.Logger().Infof("deleted Secret %s", r.Name)
-	}
-	return nil
+	log.Logger().Infof("%s %s was removed: %s", terraforms.TerraformKind, info(name), string(path))
+	return path, nil
 }
 
-func (o *Options) gcTerraformConfigMaps(ctx context.Context, createdTime *metav1.Time) error {
-	if o.TerraformConfigMapPrefix == "" {
-		o.TerraformConfigMapPrefix = defaultTerraformConfigMapPrefix
+func (o *Options) Validate() error {
+	if o.Out == nil {
+		o.Out = os.Stdout
 	}
-
-	configMapInterface := o.KubeClient.CoreV1().ConfigMaps(o.Namespace)
-
-	list, err := configMapInterface.List(ctx, metav1.ListOptions{})
-	if apierrors.IsNotFound(err) {
-		err = nil
+	o.Plan = &Plan{
+		DryRun: o.DryRun,
 	}
-	if err != nil {
-		return fmt.Errorf("failed to list ConfigMaps in namespace %s with selector %s: %w", o.Namespace, terraformStateSelector, err)
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultConcurrency
 	}
-	if list == nil {
-		return nil
+	if o.DeleteRate <= 0 {
+		o.DeleteRate = defaultDeleteRate
 	}
-
-	for _, r := range list.Items {
-		if !strings.HasPrefix(r.Name, o.TerraformConfigMapPrefix) {
-			continue
-		}
-		created := r.GetCreationTimestamp()
-		if !created.Before(createdTime) {
-			log.Logger().Debugf("not removing ConfigMap %s as it was created at %s", r.Name, created.String())
-			continue
-		}
-		err = configMapInterface.Delete(ctx, r.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to delete ConfigMap %s in namespace %s: %w", r.Name, o.Namespace, err)
-		}
-		log.Logger().Infof("deleted ConfigMap %s", r.Name)
+	if o.DeleteBurst <= 0 {
+		o.DeleteBurst = defaultDeleteBurst
 	}
-	return nil
-}
-
-func (o *Options) gcRepositories(ctx context.Context, createdTime *metav1.Time) error {
-	if o.AppID == 0 || o.AppCertificateFile == "" {
-		log.Logger().Infof("--app-id and --app-certificate-file are not specified, so no repositories are garbage collected")
-		return nil
+	if o.rateLimiter == nil {
+		o.rateLimiter = flowcontrol.NewTokenBucketRateLimiter(o.DeleteRate, o.DeleteBurst)
 	}
-	log.Logger().Infof("cleaning repositories")
-	itr, err := ghinstallation.NewAppsTransportKeyFromFile(http.DefaultTransport, o.AppID, o.AppCertificateFile)
-	if err != nil {
-		log.Logger().Fatalf("failed to configure transport as app (%d): %v", o.AppID, err)
+	switch o.OutputFormat {
+	case "", OutputFormatTable, "json", "yaml":
+	default:
+		return options.InvalidOptionf("output", o.OutputFormat, "supported values are table, json or yaml")
 	}
-	client := github.NewClient(&http.Client{Transport: itr})
-
-	installations, _, err := client.Apps.ListInstallations(context.Background(), &github.ListOptions{})
+	err := o.validateRepositoryOptions()
 	if err != nil {
-		log.Logger().Fatalf("failed to list installations: %v\n", err)
-	}
-
-	// capture our installationId for our app
-	// we need this for the access token
-	var installID int64
-	var owner string
-	for _, installation := range installations {
-		installID = installation.GetID()
-		owner = installation.GetAccount().GetLogin()
+		return err
 	}
-	log.Logger().Debugf("found installation %d for owner %s", installID, owner)
-
-	token, _, err := client.Apps.CreateInstallationToken(
-		context.Background(),
-		installID,
-		&github.InstallationTokenOptions{})
+	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
 	if err != nil {
-		return fmt.Errorf("failed to create installation token: %v", err)
+		return fmt.Errorf("failed to create kube client: %w", err)
 	}
-
-	apiClient := github.NewClient(nil).WithAuthToken(
-		token.GetToken(),
-	)
-	repos, _, err := apiClient.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{})
+	o.DynamicClient, err = kube.LazyCreateDynamicClient(o.DynamicClient)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to craete dynamic client: %w", err)
 	}
-	log.Logger().Infof("found %d repositories in %s", len(repos), owner)
-	for i := range repos {
-		repo := repos[i]
-		if !repo.CreatedAt.Before(createdTime.Time) {
-			log.Logger().Infof("not removing repository %s as it was created at %s", *repo.Name, repo.CreatedAt.String())
-			continue
-		}
-		_, err = apiClient.Repositories.Delete(ctx, owner, *repo.Name)
+	if o.TerraformVersion == nil {
+		o.TerraformVersion, err = terraforms.ResolveVersion(o.TerraformAPIVersion, o.KubeClient.Discovery())
 		if err != nil {
-			return fmt.Errorf("failed to delete the repository %s/%s: %w", *repo.Owner.Name, *repo.Name, err)
+			return options.InvalidOptionf("tf-api-version", o.TerraformAPIVersion, "%s", err.Error())
 		}
-		log.Logger().Infof("deleted repository %s", *repo.Name)
+	}
+	if o.RESTMapper == nil {
+		var mappings []dynkube.StaticMapping
+		for _, v := range terraforms.Versions {
+			mappings = append(mappings, dynkube.StaticMapping{GroupVersionKind: v.KindVersion(), Resource: v.Resource.Resource})
+		}
+		o.RESTMapper = dynkube.NewRESTMapper(o.KubeClient.Discovery(), dynkube.NewStaticRESTMapper(mappings...))
 	}
 	return nil
 }
+
+// listOptions returns the options to list a page of resources with the given label selector
+func (o *Options) listOptions(selector string) metav1.ListOptions {
+	return metav1.ListOptions{
+		LabelSelector: selector,
+		Limit:         o.PageSize,
+	}
+}
+
+// GetContext lazily creates a context if it doesn't exist already
+func (o *Options) GetContext() context.Context {
+	if o.Ctx == nil {
+		o.Ctx = context.TODO()
+	}
+	return o.Ctx
+}
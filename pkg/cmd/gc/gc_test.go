@@ -1,16 +1,32 @@
 package gc_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/gc"
+	"github.com/jenkins-x-plugins/jx-test/pkg/locks"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
 	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
-	"github.com/jenkins-x/jx-helpers/v3/pkg/cmdrunner/fakerunner"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 import (
 	"k8s.io/apimachinery/pkg/runtime"
@@ -60,8 +76,6 @@ metadata:
 func TestGC(t *testing.T) {
 	ns := "jx"
 
-	useKubectl := true
-
 	scheme := runtime.NewScheme()
 
 	now := time.Now()
@@ -78,32 +92,696 @@ func TestGC(t *testing.T) {
 		})
 	}
 
-	runner := &fakerunner.FakeRunner{}
-
 	dynObjects := tftests.ParseUnstructureds(t, fn, testResources)
 	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
 
 	_, o := gc.NewCmdGC()
 	o.Namespace = ns
 	o.DynamicClient = fakeDynClient
-	o.CommandRunner = runner.Run
 	o.KubeClient = fake.NewSimpleClientset()
 
 	err := o.Run()
 	require.NoError(t, err, "failed to run create command")
 
-	if useKubectl {
-		for _, c := range runner.OrderedCommands {
-			t.Logf("faked: %s\n", c.CLI())
+	ctx := o.GetContext()
+
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	require.NotNil(t, list, "no list resource returned")
+	require.Len(t, list.Items, 1, "should have GCd resources")
+	assert.Equal(t, "tf-myrepo-pr999-myctx-3", list.Items[0].GetName(), "remaining Terraform")
+
+	for _, item := range o.Plan.Items {
+		if item.Action == gc.ActionDelete {
+			assert.Equal(t, string(terraforms.DeletePathDeleted), item.Result, "result for %s", item.Name)
+		}
+	}
+}
+
+func TestGCDryRun(t *testing.T) {
+	ns := "jx"
+	scheme := runtime.NewScheme()
+
+	now := time.Now()
+	recentTime := metav1.Time{Time: now.Add(-1 * time.Hour)}
+	oldTime := metav1.Time{Time: now.Add(-5 * time.Hour)}
+
+	fn := func(idx int, u *unstructured.Unstructured) {
+		t := oldTime
+		if idx > 1 {
+			t = recentTime
+		}
+		u.SetCreationTimestamp(t)
+		switch idx {
+		case 0:
+			u.SetLabels(map[string]string{"kind": "jx-test", "keep": "yes"})
+		case 1:
+			// a keep label of false should not keep the resource
+			u.SetLabels(map[string]string{"kind": "jx-test", "keep": "false"})
+		}
+	}
+
+	dynObjects := tftests.ParseUnstructureds(t, fn, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(scheme, dynObjects...)
+
+	out := &bytes.Buffer{}
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.DynamicClient = fakeDynClient
+	o.DryRun = true
+	o.OutputFormat = "json"
+	o.Out = out
+	o.KubeClient = fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "tf-jx3-versions-abc", Namespace: ns, CreationTimestamp: oldTime},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "something-else", Namespace: ns, CreationTimestamp: oldTime},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-abc-state", Namespace: ns, CreationTimestamp: oldTime, Labels: map[string]string{"tfstate": "true"}},
+		},
+	)
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	ctx := o.GetContext()
+	list, err := o.Client.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	require.Len(t, list.Items, 3, "should not have removed any resources")
+
+	cmList, err := o.KubeClient.CoreV1().ConfigMaps(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list ConfigMaps")
+	require.Len(t, cmList.Items, 2, "should not have removed any ConfigMaps")
+
+	plan := &gc.Plan{}
+	err = json.Unmarshal(out.Bytes(), plan)
+	require.NoError(t, err, "failed to parse the JSON plan: %s", out.String())
+	assert.True(t, plan.DryRun, "plan.DryRun")
+
+	actions := map[string]gc.Action{}
+	for _, item := range plan.Items {
+		actions[item.Kind+"/"+item.Name] = item.Action
+		t.Logf("%s %s %s %s: %s\n", item.Action, item.Kind, item.Name, item.Age, item.Reason)
+	}
+	assert.Equal(t, map[string]gc.Action{
+		"Terraform/tf-myrepo-pr456-myctx-1": gc.ActionKeep,
+		"Terraform/tf-myrepo-pr456-myctx-2": gc.ActionDelete,
+		"Terraform/tf-myrepo-pr999-myctx-3": gc.ActionKeep,
+		"Secret/tfstate-default-abc-state":  gc.ActionDelete,
+		"ConfigMap/tf-jx3-versions-abc":     gc.ActionDelete,
+	}, actions, "plan actions")
+}
+
+func TestGCCollectors(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+
+	var deleted []string
+	registerCollector(t, gc.NewCollector("cheese",
+		func(_ context.Context) ([]*gc.Candidate, error) {
+			return []*gc.Candidate{
+				{Kind: "Cheese", Name: "edam", Created: oldTime.Time},
+				{Kind: "Cheese", Name: "brie", Created: oldTime.Time, Keep: "is tasty"},
+			}, nil
+		},
+		func(_ context.Context, c *gc.Candidate) error {
+			deleted = append(deleted, c.Name)
+			return nil
+		}))
+
+	testCases := []struct {
+		include     []string
+		exclude     []string
+		enable      []string
+		collectors  []string
+		configMaps  int
+		deleted     []string
+		expectError bool
+	}{
+		{
+			include:    []string{"cheese"},
+			collectors: []string{"cheese"},
+			configMaps: 1,
+			deleted:    []string{"edam"},
+		},
+		{
+			include:    []string{"terraform-configmap"},
+			collectors: []string{"terraform-configmap"},
+			configMaps: 0,
+		},
+		{
+			exclude:    []string{"terraform-configmap", "cheese"},
+			collectors: []string{"terraform", "lease", "lock", "terraform-state", "repository"},
+			configMaps: 1,
+		},
+		{
+			exclude:    []string{"terraform-configmap", "cheese", "repository"},
+			enable:     []string{"namespace"},
+			collectors: []string{"terraform", "lease", "lock", "terraform-state", "namespace"},
+			configMaps: 1,
+		},
+		{
+			include:     []string{"does-not-exist"},
+			expectError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		deleted = nil
+		_, o := gc.NewCmdGC()
+		o.Namespace = ns
+		o.Include = tc.include
+		o.Exclude = tc.exclude
+		o.Enable = tc.enable
+		o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+		o.KubeClient = fake.NewSimpleClientset(
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "tf-jx3-versions-abc", Namespace: ns, CreationTimestamp: oldTime},
+			},
+		)
+
+		err := o.Run()
+		if tc.expectError {
+			require.Error(t, err, "test %d should have failed", i)
+			continue
+		}
+		require.NoError(t, err, "failed to run gc command for test %d", i)
+
+		var collectors []string
+		for _, c := range mustCollectors(t, o) {
+			collectors = append(collectors, c.Name())
+		}
+		assert.Equal(t, tc.collectors, collectors, "collectors for test %d", i)
+		assert.Equal(t, tc.deleted, deleted, "deleted cheeses for test %d", i)
+
+		cmList, err := o.KubeClient.CoreV1().ConfigMaps(ns).List(o.GetContext(), metav1.ListOptions{})
+		require.NoError(t, err, "failed to list ConfigMaps")
+		assert.Len(t, cmList.Items, tc.configMaps, "ConfigMaps for test %d", i)
+	}
+}
+
+// registerCollector registers the collector for the duration of the test
+func registerCollector(t *testing.T, c gc.Collector) {
+	unregister := gc.RegisterCollector(c.Name(), func(_ *gc.Options) gc.Collector {
+		return c
+	})
+	t.Cleanup(unregister)
+}
+
+func mustCollectors(t *testing.T, o *gc.Options) []gc.Collector {
+	collectors, err := o.Collectors()
+	require.NoError(t, err, "failed to create collectors")
+	return collectors
+}
+
+func TestGCTerraformState(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+	stateLabels := map[string]string{"tfstate": "true"}
+
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources[2:])
+	fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+
+	out := &bytes.Buffer{}
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorLease, gc.CollectorTerraformState}
+	o.DynamicClient = fakeDynClient
+	o.OutputFormat = gc.OutputFormatTable
+	o.Out = out
+	o.KubeClient = fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-myrepo-pr999-myctx-3-state", Namespace: ns, CreationTimestamp: oldTime, Labels: stateLabels},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-destroying-state", Namespace: ns, CreationTimestamp: oldTime, Labels: stateLabels},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-orphan-state", Namespace: ns, CreationTimestamp: oldTime, Labels: stateLabels},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "lock-tfstate-default-tf-orphan-state", Namespace: ns, CreationTimestamp: oldTime, Labels: stateLabels},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "tf-destroying-destroy", Namespace: ns},
+		},
+	)
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	ctx := o.GetContext()
+	secretList, err := o.KubeClient.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Secrets")
+	var secretNames []string
+	for i := range secretList.Items {
+		secretNames = append(secretNames, secretList.Items[i].Name)
+	}
+	assert.ElementsMatch(t, []string{"tfstate-default-tf-myrepo-pr999-myctx-3-state", "tfstate-default-tf-destroying-state"}, secretNames, "remaining state Secrets")
+
+	leaseList, err := o.KubeClient.CoordinationV1().Leases(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Leases")
+	assert.Empty(t, leaseList.Items, "should have removed the orphaned Lease")
+
+	orphans := o.Plan.Orphans()
+	require.Len(t, orphans, 2, "orphaned state")
+	for _, item := range orphans {
+		assert.Equal(t, "tf-orphan", item.Owner, "owner of %s %s", item.Kind, item.Name)
+		assert.Equal(t, gc.ActionDelete, item.Action, "action for %s %s", item.Kind, item.Name)
+	}
+	for _, item := range o.Plan.Items {
+		switch item.Owner {
+		case "tf-myrepo-pr999-myctx-3":
+			assert.Contains(t, item.Reason, "still exists", "reason for %s", item.Name)
+		case "tf-destroying":
+			assert.Contains(t, item.Reason, "Job tf-destroying-destroy", "reason for %s", item.Name)
+		}
+	}
+	assert.Contains(t, out.String(), "orphaned resources:", "should report orphaned resources")
+}
+
+func TestGCLocks(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+	testLabels := map[string]string{"kind": "jx-test", "repo": "myrepo"}
+	seconds := int32(300)
+	lock := func(name, build string, renewed time.Time) *coordinationv1.Lease {
+		holder := "pod-" + build
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         ns,
+				CreationTimestamp: oldTime,
+				Labels:            testLabels,
+				Annotations:       map[string]string{locks.AnnotationBuild: build, locks.AnnotationSelector: "kind=jx-test,repo=myrepo"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				RenewTime:            &renewTime,
+			},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		lock("jx-test-lock-expired", "3", oldTime.Time),
+		lock("jx-test-lock-held", "4", time.Now()),
+		lock("jx-test-lock-retaken", "5", oldTime.Time),
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "something-else", Namespace: ns, CreationTimestamp: oldTime, Labels: testLabels},
+		},
+	)
+	// lets simulate another build taking the lock after it was checked
+	kubeClient.PrependReactor("delete", "leases", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		name := action.(clienttesting.DeleteAction).GetName()
+		if name != "jx-test-lock-retaken" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewConflict(coordinationv1.Resource("leases"), name, fmt.Errorf("the lease was renewed"))
+	})
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorLock}
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	leaseList, err := kubeClient.CoordinationV1().Leases(ns).List(o.GetContext(), metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Leases")
+	var names []string
+	for i := range leaseList.Items {
+		names = append(names, leaseList.Items[i].Name)
+	}
+	assert.ElementsMatch(t, []string{"jx-test-lock-held", "jx-test-lock-retaken", "something-else"}, names, "should only remove the expired lock Lease")
+
+	actions := map[string]gc.Action{}
+	for _, item := range o.Plan.Items {
+		actions[item.Name] = item.Action
+	}
+	assert.Equal(t, map[string]gc.Action{
+		"jx-test-lock-expired": gc.ActionDelete,
+		"jx-test-lock-held":    gc.ActionKeep,
+		"jx-test-lock-retaken": gc.ActionKeep,
+	}, actions, "plan actions")
+}
+
+func TestGCV1Beta1(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+
+	fn := func(idx int, u *unstructured.Unstructured) {
+		u.SetAPIVersion("tf.galleybytes.com/v1beta1")
+		if idx < 2 {
+			u.SetCreationTimestamp(oldTime)
+		} else {
+			u.SetCreationTimestamp(metav1.Now())
+		}
+	}
+	dynObjects := tftests.ParseUnstructureds(t, fn, testResources)
+	fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+
+	kubeClient := fake.NewSimpleClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "tf-myrepo-pr456-myctx-1-1-apply",
+				Namespace: ns,
+				Labels:    map[string]string{terraforms.V1Beta1.ResourceLabel: "tf-myrepo-pr456-myctx-1"},
+			},
+		},
+	)
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "tf.galleybytes.com/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "terraforms", Kind: "Terraform", Namespaced: true}},
+		},
+	}
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorTerraform}
+	o.DynamicClient = fakeDynClient
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+	assert.Equal(t, terraforms.V1Beta1, o.TerraformVersion, "detected Terraform API version")
+
+	ctx := o.GetContext()
+	list, err := fakeDynClient.Resource(terraforms.V1Beta1.Resource).Namespace(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list resources")
+	require.Len(t, list.Items, 1, "should have GCd resources")
+	assert.Equal(t, "tf-myrepo-pr999-myctx-3", list.Items[0].GetName(), "remaining Terraform")
+
+	jobList, err := kubeClient.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Jobs")
+	assert.Empty(t, jobList.Items, "should have removed the active apply Job")
+}
+
+func TestGCClusterResources(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+	testLabels := map[string]string{"kind": "jx-test"}
+	orphanAnnotations := map[string]string{terraforms.AnnotationOwner: "tf.isaaguilar.com/v1alpha1/Terraform/jx/tf-gone"}
+	ownedAnnotations := map[string]string{terraforms.AnnotationOwner: "tf.isaaguilar.com/v1alpha1/Terraform/jx/tf-myrepo-pr999-myctx-3"}
+	configMapOwnedAnnotations := map[string]string{terraforms.AnnotationOwner: "v1/ConfigMap/jx/test-vars"}
+	unknownOwnerAnnotations := map[string]string{terraforms.AnnotationOwner: "example.com/v1/Cheese/jx/edam"}
+
+	testVars := &unstructured.Unstructured{}
+	testVars.SetAPIVersion("v1")
+	testVars.SetKind("ConfigMap")
+	testVars.SetName("test-vars")
+	testVars.SetNamespace(ns)
+
+	newClusterRole := func(name string, created metav1.Time, labels, annotations map[string]string) runtime.Object {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("rbac.authorization.k8s.io/v1")
+		u.SetKind("ClusterRole")
+		u.SetName(name)
+		u.SetCreationTimestamp(created)
+		u.SetLabels(labels)
+		u.SetAnnotations(annotations)
+		return u
+	}
+	dynObjects := tftests.ParseUnstructureds(t, nil, testResources[2:])
+	dynObjects = append(dynObjects,
+		newClusterRole("orphan-role", oldTime, testLabels, orphanAnnotations),
+		newClusterRole("owned-role", oldTime, testLabels, ownedAnnotations),
+		newClusterRole("other-role", oldTime, nil, orphanAnnotations),
+		testVars,
+	)
+	fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns, CreationTimestamp: oldTime, Labels: testLabels}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", CreationTimestamp: oldTime}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-orphan", CreationTimestamp: oldTime, Labels: testLabels, Annotations: orphanAnnotations}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-owned", CreationTimestamp: oldTime, Labels: testLabels, Annotations: ownedAnnotations}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-cm-owned", CreationTimestamp: oldTime, Labels: testLabels, Annotations: configMapOwnedAnnotations}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-unknown-owner", CreationTimestamp: oldTime, Labels: testLabels, Annotations: unknownOwnerAnnotations}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-old", CreationTimestamp: oldTime, Labels: testLabels}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-new", CreationTimestamp: metav1.Now(), Labels: testLabels}},
+	)
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"list", "delete"}},
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "delete"}},
+			},
+		},
+		{
+			GroupVersion: "rbac.authorization.k8s.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "clusterroles", Kind: "ClusterRole", Verbs: metav1.Verbs{"list", "delete"}},
+			},
+		},
+	}
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorNamespace, gc.CollectorClusterResource}
+	o.DynamicClient = fakeDynClient
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	ctx := o.GetContext()
+	nsList, err := kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list Namespaces")
+	var namespaces []string
+	for i := range nsList.Items {
+		namespaces = append(namespaces, nsList.Items[i].Name)
+	}
+	assert.ElementsMatch(t, []string{ns, "default", "test-owned", "test-cm-owned", "test-unknown-owner", "test-new"}, namespaces, "remaining Namespaces")
+
+	roleList, err := fakeDynClient.Resource(tftests.ClusterRoleResource).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err, "failed to list ClusterRoles")
+	var roles []string
+	for i := range roleList.Items {
+		roles = append(roles, roleList.Items[i].GetName())
+	}
+	assert.ElementsMatch(t, []string{"owned-role", "other-role"}, roles, "remaining ClusterRoles")
+
+	for _, item := range o.Plan.Orphans() {
+		assert.Equal(t, "jx/tf-gone", item.Owner, "owner of %s %s", item.Kind, item.Name)
+	}
+	for _, item := range o.Plan.Items {
+		switch item.Name {
+		case "test-cm-owned":
+			assert.Equal(t, "ConfigMap jx/test-vars still exists", item.Reason, "reason for %s", item.Name)
+		case "test-unknown-owner":
+			assert.Contains(t, item.Reason, "cannot be resolved", "reason for %s", item.Name)
+		}
+	}
+	assert.Len(t, o.Plan.Orphans(), 2, "orphaned resources")
+}
+
+func TestGCTerraformConfigMapSelector(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+	tfLabels := map[string]string{"app": "tf-versions"}
+
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "versions-a", Namespace: ns, CreationTimestamp: oldTime, Labels: tfLabels}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "versions-b", Namespace: ns, CreationTimestamp: oldTime, Labels: tfLabels}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "versions-new", Namespace: ns, CreationTimestamp: metav1.Now(), Labels: tfLabels}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "tf-jx3-versions-abc", Namespace: ns, CreationTimestamp: oldTime}},
+	)
+
+	// lets return one ConfigMap per page to check the Continue tokens are followed
+	var requests []metav1.ListOptions
+	kubeClient.PrependReactor("list", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		listAction := action.(clienttesting.ListActionImpl)
+		listOptions := listAction.ListOptions
+		requests = append(requests, listOptions)
+
+		obj, err := kubeClient.Tracker().List(corev1.SchemeGroupVersion.WithResource("configmaps"), corev1.SchemeGroupVersion.WithKind("ConfigMap"), ns)
+		if err != nil {
+			return true, nil, err
+		}
+		selector, err := labels.Parse(listOptions.LabelSelector)
+		require.NoError(t, err, "failed to parse selector")
+
+		var items []corev1.ConfigMap
+		for _, cm := range obj.(*corev1.ConfigMapList).Items {
+			if selector.Matches(labels.Set(cm.Labels)) {
+				items = append(items, cm)
+			}
 		}
-	} else {
-		ctx := o.GetContext()
+		page := 0
+		if listOptions.Continue != "" {
+			page, err = strconv.Atoi(listOptions.Continue)
+			require.NoError(t, err, "failed to parse continue token")
+		}
+		answer := &corev1.ConfigMapList{}
+		if page < len(items) {
+			answer.Items = items[page : page+1]
+		}
+		if page+1 < len(items) {
+			answer.Continue = strconv.Itoa(page + 1)
+		}
+		return true, answer, nil
+	})
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorTerraformConfigMap}
+	o.TerraformConfigMapFilter.Selectors = []string{"app=tf-versions"}
+	o.PageSize = 1
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	require.Len(t, requests, 3, "should have listed a page per ConfigMap")
+	for i, r := range requests {
+		assert.Equal(t, "app=tf-versions", r.LabelSelector, "selector of request %d", i)
+		assert.Equal(t, int64(1), r.Limit, "limit of request %d", i)
+	}
+
+	cmList, err := kubeClient.Tracker().List(corev1.SchemeGroupVersion.WithResource("configmaps"), corev1.SchemeGroupVersion.WithKind("ConfigMap"), ns)
+	require.NoError(t, err, "failed to list ConfigMaps")
+	var names []string
+	for _, cm := range cmList.(*corev1.ConfigMapList).Items {
+		names = append(names, cm.Name)
+	}
+	assert.ElementsMatch(t, []string{"versions-new", "tf-jx3-versions-abc"}, names, "remaining ConfigMaps")
+}
+
+func TestGCDeleteConcurrently(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+	names := []string{"brie", "cheddar", "edam", "gouda", "stilton", "wensleydale"}
+
+	var lock sync.Mutex
+	var attempted []string
+	active := 0
+	maxActive := 0
+	registerCollector(t, gc.NewCollector("cheese",
+		func(_ context.Context) ([]*gc.Candidate, error) {
+			var answer []*gc.Candidate
+			for _, name := range names {
+				answer = append(answer, &gc.Candidate{Kind: "Cheese", Name: name, Created: oldTime.Time})
+			}
+			answer = append(answer, &gc.Candidate{Kind: "Cheese", Name: "mozzarella", Created: time.Now()})
+			return answer, nil
+		},
+		func(_ context.Context, c *gc.Candidate) error {
+			lock.Lock()
+			attempted = append(attempted, c.Name)
+			active++
+			maxActive = max(maxActive, active)
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			active--
+			lock.Unlock()
+			if c.Name == "edam" || c.Name == "stilton" {
+				return fmt.Errorf("%s is too smelly", c.Name)
+			}
+			return nil
+		}))
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{"cheese"}
+	o.Concurrency = 3
+	o.DeleteRate = 1000
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset()
 
-		list, err := o.Client.List(ctx, metav1.ListOptions{})
-		require.NoError(t, err, "failed to list resources")
-		require.NotNil(t, list, "no list resource returned")
-		require.Len(t, list.Items, 1, "should have GCd resources")
+	err := o.Run()
+	require.Error(t, err, "should have failed to delete some cheeses")
+	assert.Contains(t, err.Error(), "failed to garbage collect 0 collectors and 2 resources", "error")
+	assert.Contains(t, err.Error(), "Cheese edam: edam is too smelly", "error")
+	assert.Contains(t, err.Error(), "Cheese stilton: stilton is too smelly", "error")
+
+	assert.ElementsMatch(t, names, attempted, "should have tried to delete every old cheese")
+	assert.LessOrEqual(t, maxActive, 3, "concurrent deletions")
+	assert.Greater(t, maxActive, 1, "should have deleted in parallel")
+	require.NotNil(t, o.Plan.Summary, "summary")
+	assert.Equal(t, []*gc.CollectorSummary{{Collector: "cheese", Deleted: 4, Skipped: 1, Failed: 2}}, o.Plan.Summary.Collectors, "summary")
+
+	for _, item := range o.Plan.Items {
+		if item.Name == "edam" {
+			assert.Equal(t, gc.ResultFailed, item.Result, "result of %s", item.Name)
+		}
+	}
+}
+
+func TestGCContinueOnError(t *testing.T) {
+	ns := "jx"
+	oldTime := metav1.Time{Time: time.Now().Add(-5 * time.Hour)}
+
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "tf-jx3-versions-abc", Namespace: ns, CreationTimestamp: oldTime},
+		},
+	)
+	kubeClient.PrependReactor("list", "leases", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("leases are broken")
+	})
+
+	out := &bytes.Buffer{}
+	_, o := gc.NewCmdGC()
+	o.Namespace = ns
+	o.Include = []string{gc.CollectorLease, gc.CollectorTerraformConfigMap}
+	o.OutputFormat = "json"
+	o.Out = out
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.Error(t, err, "should have failed to list the Leases")
+	assert.Contains(t, err.Error(), "leases are broken", "error")
 
-		t.Logf("has remaining Terraform %s\n", list.Items[0].GetName())
+	cmList, err := kubeClient.CoreV1().ConfigMaps(ns).List(o.GetContext(), metav1.ListOptions{})
+	require.NoError(t, err, "failed to list ConfigMaps")
+	assert.Empty(t, cmList.Items, "should have removed the ConfigMaps after the lease collector failed")
+
+	plan := &gc.Plan{}
+	err = json.Unmarshal(out.Bytes(), plan)
+	require.NoError(t, err, "failed to parse plan %s", out.String())
+	require.Len(t, plan.Failures, 1, "failures")
+	assert.Equal(t, gc.CollectorLease, plan.Failures[0].Collector, "failed collector")
+	require.NotNil(t, plan.Summary, "summary")
+	assert.Equal(t, 1, plan.Summary.Deleted, "deleted")
+	require.Len(t, plan.Summary.Collectors, 2, "collector summaries")
+	for _, cs := range plan.Summary.Collectors {
+		switch cs.Collector {
+		case gc.CollectorLease:
+			assert.Contains(t, cs.Error, "leases are broken", "lease collector error")
+		case gc.CollectorTerraformConfigMap:
+			assert.Equal(t, 1, cs.Deleted, "deleted ConfigMaps")
+		default:
+			assert.Fail(t, "unexpected collector", "collector %s", cs.Collector)
+		}
 	}
 }
+
+func TestGCNamespacesForbidden(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "namespaces", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "", errors.New("no cluster role"))
+	})
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = "jx"
+	o.Include = []string{gc.CollectorNamespace}
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = kubeClient
+
+	err := o.Run()
+	require.NoError(t, err, "should skip Namespaces if listing them is forbidden")
+	assert.Empty(t, o.Plan.Items, "plan items")
+}
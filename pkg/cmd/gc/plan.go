@@ -0,0 +1,217 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/outputformat"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/table"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// Action the action taken for a garbage collection candidate
+type Action string
+
+const (
+	// ActionDelete the candidate is deleted
+	ActionDelete Action = "delete"
+
+	// ActionKeep the candidate is kept
+	ActionKeep Action = "keep"
+
+	// ResultFailed the result of a candidate which could not be deleted
+	ResultFailed = "failed"
+
+	// OutputFormatTable renders the plan as a table
+	OutputFormatTable = "table"
+)
+
+// PlanItem a candidate for garbage collection along with the action taken and why
+type PlanItem struct {
+	Collector string    `json:"collector"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	Owner     string    `json:"owner,omitempty"`
+	Orphaned  bool      `json:"orphaned,omitempty"`
+	Age       string    `json:"age"`
+	Action    Action    `json:"action"`
+	Reason    string    `json:"reason"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Plan the candidates for garbage collection
+type Plan struct {
+	DryRun   bool                `json:"dryRun"`
+	Items    []*PlanItem         `json:"items"`
+	Failures []*CollectorFailure `json:"failures,omitempty"`
+	Summary  *Summary            `json:"summary,omitempty"`
+}
+
+// CollectorFailure a collector which failed to list its candidates. Any candidates it did list are still
+// garbage collected
+type CollectorFailure struct {
+	Collector string `json:"collector"`
+	Error     string `json:"error"`
+
+	err error
+}
+
+// Add adds a new item to the plan
+func (p *Plan) Add(item *PlanItem) {
+	if item.Age == "" && !item.Created.IsZero() {
+		item.Age = duration.HumanDuration(time.Since(item.Created))
+	}
+	p.Items = append(p.Items, item)
+}
+
+// Count returns the number of items with the given action
+func (p *Plan) Count(action Action) int {
+	count := 0
+	for i := range p.Items {
+		if p.Items[i].Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary the number of candidates deleted, kept and which failed to be deleted in total and by each collector
+type Summary struct {
+	Deleted    int                 `json:"deleted"`
+	Skipped    int                 `json:"skipped"`
+	Failed     int                 `json:"failed"`
+	Collectors []*CollectorSummary `json:"collectors,omitempty"`
+}
+
+// CollectorSummary the number of candidates of a collector deleted, kept and which failed to be deleted along
+// with any error listing the candidates
+type CollectorSummary struct {
+	Collector string `json:"collector"`
+	Deleted   int    `json:"deleted"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AddFailure records that the collector failed to list its candidates
+func (p *Plan) AddFailure(collector string, err error) {
+	p.Failures = append(p.Failures, &CollectorFailure{
+		Collector: collector,
+		Error:     err.Error(),
+		err:       err,
+	})
+}
+
+// Summarise summarises the actions taken. In dry run mode the deleted count is the number which would be deleted
+func (p *Plan) Summarise() *Summary {
+	answer := &Summary{}
+	collectors := map[string]*CollectorSummary{}
+	collectorSummary := func(name string) *CollectorSummary {
+		cs := collectors[name]
+		if cs == nil {
+			cs = &CollectorSummary{Collector: name}
+			collectors[name] = cs
+			answer.Collectors = append(answer.Collectors, cs)
+		}
+		return cs
+	}
+	for _, item := range p.Items {
+		cs := collectorSummary(item.Collector)
+		switch {
+		case item.Error != "":
+			answer.Failed++
+			cs.Failed++
+		case item.Action == ActionDelete:
+			answer.Deleted++
+			cs.Deleted++
+		default:
+			answer.Skipped++
+			cs.Skipped++
+		}
+	}
+	for _, f := range p.Failures {
+		collectorSummary(f.Collector).Error = f.Error
+	}
+	return answer
+}
+
+// Errors returns the errors of the collectors which failed to list their candidates and of the candidates which
+// failed to be deleted
+func (p *Plan) Errors() []error {
+	var answer []error
+	for _, f := range p.Failures {
+		answer = append(answer, fmt.Errorf("failed to GC %s: %w", f.Collector, f.err))
+	}
+	for _, item := range p.Items {
+		if item.Error != "" {
+			answer = append(answer, errors.New(item.Error))
+		}
+	}
+	return answer
+}
+
+// Orphans returns the items whose owning resource no longer exists
+func (p *Plan) Orphans() []*PlanItem {
+	var answer []*PlanItem
+	for _, item := range p.Items {
+		if item.Orphaned {
+			answer = append(answer, item)
+		}
+	}
+	return answer
+}
+
+// Print prints the plan in the given format: table, json or yaml
+func (p *Plan) Print(out io.Writer, format string) error {
+	if format == "" || format == OutputFormatTable {
+		t := table.CreateTable(out)
+		t.AddRow("COLLECTOR", "KIND", "NAME", "AGE", "ACTION", "REASON", "RESULT")
+		for i := range p.Items {
+			item := p.Items[i]
+			t.AddRow(item.Collector, item.Kind, item.Name, item.Age, string(item.Action), item.Reason, item.Result)
+		}
+		t.Render()
+
+		orphans := p.Orphans()
+		if len(orphans) > 0 {
+			_, err := fmt.Fprintf(out, "\norphaned resources:\n")
+			if err != nil {
+				return fmt.Errorf("failed to output plan: %w", err)
+			}
+			t = table.CreateTable(out)
+			t.AddRow("KIND", "NAME", "OWNER", "AGE", "ACTION")
+			for _, item := range orphans {
+				t.AddRow(item.Kind, item.Name, item.Owner, item.Age, string(item.Action))
+			}
+			t.Render()
+		}
+
+		if p.Summary == nil {
+			return nil
+		}
+		_, err := fmt.Fprintf(out, "\nsummary:\n")
+		if err != nil {
+			return fmt.Errorf("failed to output plan: %w", err)
+		}
+		t = table.CreateTable(out)
+		t.AddRow("COLLECTOR", "DELETED", "SKIPPED", "FAILED", "ERROR")
+		for _, cs := range p.Summary.Collectors {
+			t.AddRow(cs.Collector, strconv.Itoa(cs.Deleted), strconv.Itoa(cs.Skipped), strconv.Itoa(cs.Failed), cs.Error)
+		}
+		t.AddRow("TOTAL", strconv.Itoa(p.Summary.Deleted), strconv.Itoa(p.Summary.Skipped), strconv.Itoa(p.Summary.Failed), "")
+		t.Render()
+		return nil
+	}
+	err := outputformat.Marshal(p, out, format)
+	if err != nil {
+		return fmt.Errorf("failed to output plan: %w", err)
+	}
+	_, err = fmt.Fprintln(out)
+	return err
+}
@@ -0,0 +1,198 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v69/github"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/stringhelpers"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+)
+
+const (
+	// accountTypeOrganization the type of GitHub account for organisations
+	accountTypeOrganization = "Organization"
+
+	repositoryPageSize = 100
+)
+
+// repositoryCollector garbage collects the test repositories created in the organisations of all the
+// installations of the GitHub App
+type repositoryCollector struct {
+	o       *Options
+	clients map[string]*github.Client
+}
+
+func newRepositoryCollector(o *Options) Collector {
+	return &repositoryCollector{
+		o:       o,
+		clients: map[string]*github.Client{},
+	}
+}
+
+func (c *repositoryCollector) Name() string {
+	return CollectorRepository
+}
+
+func (c *repositoryCollector) List(ctx context.Context) ([]*Candidate, error) {
+	o := c.o
+	if o.AppID == 0 || o.AppCertificateFile == "" {
+		log.Logger().Infof("--app-id and --app-certificate-file are not specified, so no repositories are garbage collected")
+		return nil, nil
+	}
+	if o.RepositoryRegex == "" && o.RepositoryTopic == "" {
+		log.Logger().Warnf("neither --repo-regex nor --repo-topic are specified, so no repositories are garbage collected")
+		return nil, nil
+	}
+	log.Logger().Infof("cleaning repositories")
+
+	itr, err := ghinstallation.NewAppsTransportKeyFromFile(http.DefaultTransport, o.AppID, o.AppCertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure transport as app (%d): %w", o.AppID, err)
+	}
+	client, err := o.newGitHubClient(&http.Client{Transport: itr})
+	if err != nil {
+		return nil, err
+	}
+
+	installations, err := listInstallations(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// lets carry on with the other organisations if one fails
+	var answer []*Candidate
+	var errs []error
+	for _, installation := range installations {
+		installID := installation.GetID()
+		owner := installation.GetAccount().GetLogin()
+		accountType := installation.GetAccount().GetType()
+		if accountType != accountTypeOrganization {
+			log.Logger().Infof("ignoring installation %d for %s %s as it is not an organisation", installID, accountType, owner)
+			continue
+		}
+		log.Logger().Debugf("found installation %d for owner %s", installID, owner)
+
+		token, _, err := client.Apps.CreateInstallationToken(ctx, installID, &github.InstallationTokenOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create installation token for installation %d of owner %s: %w", installID, owner, err))
+			continue
+		}
+		apiClient, err := o.newGitHubClient(nil)
+		if err != nil {
+			return nil, err
+		}
+		apiClient = apiClient.WithAuthToken(token.GetToken())
+		c.clients[owner] = apiClient
+
+		repos, err := listOrgRepositories(ctx, apiClient, owner)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Logger().Infof("found %d repositories in %s", len(repos), owner)
+
+		for _, repo := range repos {
+			answer = append(answer, &Candidate{
+				Kind:      "Repository",
+				Namespace: owner,
+				Name:      repo.GetName(),
+				Created:   repo.GetCreatedAt().Time,
+				Keep:      o.keepRepository(owner, repo),
+			})
+		}
+	}
+	return answer, errors.Join(errs...)
+}
+
+func (c *repositoryCollector) Delete(ctx context.Context, candidate *Candidate) error {
+	apiClient := c.clients[candidate.Namespace]
+	if apiClient == nil {
+		return fmt.Errorf("no GitHub client for owner %s", candidate.Namespace)
+	}
+	_, err := apiClient.Repositories.Delete(ctx, candidate.Namespace, candidate.Name)
+	if err != nil {
+		return fmt.Errorf("failed to delete the repository %s/%s: %w", candidate.Namespace, candidate.Name, err)
+	}
+	return nil
+}
+
+// keepRepository returns the reason the repository must be kept or an empty string if it can be garbage collected
+func (o *Options) keepRepository(owner string, repo *github.Repository) string {
+	name := repo.GetName()
+	if stringhelpers.StringArrayIndex(o.RepositoryKeep, name) >= 0 || stringhelpers.StringArrayIndex(o.RepositoryKeep, owner+"/"+name) >= 0 {
+		return "is in the repository allow list"
+	}
+	if o.repositoryRegex != nil && !o.repositoryRegex.MatchString(name) {
+		return fmt.Sprintf("name does not match regex %s", o.RepositoryRegex)
+	}
+	if o.RepositoryTopic != "" && stringhelpers.StringArrayIndex(repo.Topics, o.RepositoryTopic) < 0 {
+		return fmt.Sprintf("does not have topic %s", o.RepositoryTopic)
+	}
+	return ""
+}
+
+// validateRepositoryOptions validates the repository filter options
+func (o *Options) validateRepositoryOptions() error {
+	o.repositoryRegex = nil
+	if o.RepositoryRegex == "" {
+		return nil
+	}
+	var err error
+	o.repositoryRegex, err = regexp.Compile(o.RepositoryRegex)
+	if err != nil {
+		return fmt.Errorf("failed to parse option --repo-regex %s: %w", o.RepositoryRegex, err)
+	}
+	return nil
+}
+
+// newGitHubClient creates a GitHub client using the optional GitHub URL for GitHub Enterprise
+func (o *Options) newGitHubClient(httpClient *http.Client) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if o.GitHubURL == "" {
+		return client, nil
+	}
+	client, err := client.WithEnterpriseURLs(o.GitHubURL, o.GitHubURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use GitHub URL %s: %w", o.GitHubURL, err)
+	}
+	return client, nil
+}
+
+func listInstallations(ctx context.Context, client *github.Client) ([]*github.Installation, error) {
+	var answer []*github.Installation
+	opts := &github.ListOptions{PerPage: repositoryPageSize}
+	for {
+		installations, resp, err := client.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installations: %w", err)
+		}
+		answer = append(answer, installations...)
+		if resp == nil || resp.NextPage == 0 {
+			return answer, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func listOrgRepositories(ctx context.Context, client *github.Client, owner string) ([]*github.Repository, error) {
+	var answer []*github.Repository
+	opts := &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{PerPage: repositoryPageSize},
+	}
+	for {
+		repos, resp, err := client.Repositories.ListByOrg(ctx, owner, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories of %s: %w", owner, err)
+		}
+		answer = append(answer, repos...)
+		if resp == nil || resp.NextPage == 0 {
+			return answer, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,177 @@
+package gc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/gc"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGCRepositories(t *testing.T) {
+	oldTime := time.Now().Add(-5 * time.Hour).UTC().Format(time.RFC3339)
+	recentTime := time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339)
+
+	var lock sync.Mutex
+	var deleted []string
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repo := func(name, created string, topics ...string) string {
+		return fmt.Sprintf(`{"name": %q, "created_at": %q, "topics": [%s]}`, name, created, quoteAll(topics))
+	}
+
+	mux.HandleFunc("GET /api/v3/app/installations", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `[
+  {"id": 1, "account": {"login": "org-a", "type": "Organization"}},
+  {"id": 2, "account": {"login": "user-b", "type": "User"}},
+  {"id": 3, "account": {"login": "org-c", "type": "Organization"}}
+]`)
+	})
+	mux.HandleFunc("POST /api/v3/app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token-%s"}`, r.PathValue("id"))
+	})
+	mux.HandleFunc("GET /api/v3/orgs/org-a/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprintf(w, "[%s, %s]", repo("test-repo-2", oldTime), repo("test-repo-3", recentTime))
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/orgs/org-a/repos?page=2>; rel="next"`, server.URL))
+		fmt.Fprintf(w, "[%s, %s]", repo("test-repo-1", oldTime), repo("production", oldTime))
+	})
+	mux.HandleFunc("GET /api/v3/orgs/org-c/repos", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "[%s]", repo("test-repo-x", oldTime))
+	})
+	mux.HandleFunc("DELETE /api/v3/repos/{owner}/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		deleted = append(deleted, r.PathValue("owner")+"/"+r.PathValue("repo"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = "jx"
+	o.Include = []string{gc.CollectorRepository}
+	o.AppID = 1234
+	o.AppCertificateFile = writePrivateKey(t)
+	o.GitHubURL = server.URL
+	o.RepositoryRegex = "^test-"
+	o.RepositoryKeep = []string{"org-a/test-repo-2"}
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run gc command")
+
+	sort.Strings(deleted)
+	assert.Equal(t, []string{"org-a/test-repo-1", "org-c/test-repo-x"}, deleted, "deleted repositories")
+
+	reasons := map[string]string{}
+	for _, item := range o.Plan.Items {
+		reasons[item.Namespace+"/"+item.Name] = item.Reason
+	}
+	assert.Contains(t, reasons["org-a/production"], "does not match regex", "reason for org-a/production")
+	assert.Contains(t, reasons["org-a/test-repo-2"], "allow list", "reason for org-a/test-repo-2")
+	assert.Contains(t, reasons["org-a/test-repo-3"], "too young", "reason for org-a/test-repo-3")
+}
+
+func TestGCRepositoriesNeedsFilter(t *testing.T) {
+	_, o := gc.NewCmdGC()
+	o.Namespace = "jx"
+	o.Include = []string{gc.CollectorRepository}
+	o.AppID = 1234
+	o.AppCertificateFile = "does-not-exist.pem"
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err := o.Run()
+	require.NoError(t, err, "should not garbage collect repositories without a filter")
+	assert.Empty(t, o.Plan.Items, "should not have listed any repositories")
+}
+
+func TestGCRepositoriesContinueOnError(t *testing.T) {
+	oldTime := time.Now().Add(-5 * time.Hour).UTC().Format(time.RFC3339)
+
+	var lock sync.Mutex
+	var deleted []string
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("GET /api/v3/app/installations", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `[
+  {"id": 1, "account": {"login": "org-a", "type": "Organization"}},
+  {"id": 3, "account": {"login": "org-c", "type": "Organization"}}
+]`)
+	})
+	mux.HandleFunc("POST /api/v3/app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token-%s"}`, r.PathValue("id"))
+	})
+	mux.HandleFunc("GET /api/v3/orgs/org-a/repos", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"message": "boom"}`, http.StatusInternalServerError)
+	})
+	mux.HandleFunc("GET /api/v3/orgs/org-c/repos", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `[{"name": "test-repo-x", "created_at": %q}]`, oldTime)
+	})
+	mux.HandleFunc("DELETE /api/v3/repos/{owner}/{repo}", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		deleted = append(deleted, r.PathValue("owner")+"/"+r.PathValue("repo"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, o := gc.NewCmdGC()
+	o.Namespace = "jx"
+	o.Include = []string{gc.CollectorRepository}
+	o.AppID = 1234
+	o.AppCertificateFile = writePrivateKey(t)
+	o.GitHubURL = server.URL
+	o.RepositoryRegex = "^test-"
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset()
+
+	err := o.Run()
+	require.Error(t, err, "should have failed to list the repositories of org-a")
+	assert.Contains(t, err.Error(), "org-a", "error")
+	assert.Equal(t, []string{"org-c/test-repo-x"}, deleted, "should still delete the repositories of org-c")
+}
+
+func writePrivateKey(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err, "failed to generate key")
+
+	path := filepath.Join(t.TempDir(), "app.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	err = os.WriteFile(path, data, 0o600)
+	require.NoError(t, err, "failed to write key %s", path)
+	return path
+}
+
+func quoteAll(values []string) string {
+	var quoted []string
+	for _, v := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", v))
+	}
+	return strings.Join(quoted, ", ")
+}
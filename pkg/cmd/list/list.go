@@ -0,0 +1,321 @@
+package list
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/policy"
+	"github.com/jenkins-x-plugins/jx-test/pkg/root"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/helper"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/templates"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/jobs"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/naming"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/outputformat"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/table"
+
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	cmdLong = templates.LongDesc(`
+		Lists the active test resources and their status
+`)
+
+	cmdExample = templates.Examples(`
+		%s list
+
+		# list the tests of a pull request as YAML
+		%s list --repo myrepo --pr 123 -o yaml
+	`)
+)
+
+const (
+	// OutputFormatTable renders the tests as a table
+	OutputFormatTable = "table"
+
+	// JobPhaseNone there is no Job for the test
+	JobPhaseNone = "None"
+
+	// JobPhaseRunning the Job is running
+	JobPhaseRunning = "Running"
+
+	// JobPhaseSucceeded the Job succeeded
+	JobPhaseSucceeded = "Succeeded"
+
+	// JobPhaseFailed the Job failed
+	JobPhaseFailed = "Failed"
+)
+
+// Test an active test resource and its status
+type Test struct {
+	Name       string    `json:"name"`
+	Namespace  string    `json:"namespace"`
+	Owner      string    `json:"owner,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	PR         string    `json:"pr,omitempty"`
+	Context    string    `json:"context,omitempty"`
+	Build      string    `json:"build,omitempty"`
+	Created    time.Time `json:"created"`
+	Age        string    `json:"age"`
+	Keep       bool      `json:"keep"`
+	KeepReason string    `json:"keepReason,omitempty"`
+	JobPhase   string    `json:"jobPhase"`
+	State      bool      `json:"state"`
+}
+
+// Options the options for the command
+type Options struct {
+	Namespace           string
+	Selector            string
+	Repository          string
+	PullRequestNumber   int
+	MinAge              time.Duration
+	MaxAge              time.Duration
+	OutputFormat        string
+	TerraformAPIVersion string
+	TerraformVersion    *terraforms.Version
+	KubeClient          kubernetes.Interface
+	DynamicClient       dynamic.Interface
+	Ctx                 context.Context
+	Out                 io.Writer
+	Tests               []*Test
+}
+
+// NewCmdList creates a command object for the command
+func NewCmdList() (*cobra.Command, *Options) {
+	o := &Options{}
+
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "Lists the active test resources and their status",
+		Long:    cmdLong,
+		Example: fmt.Sprintf(cmdExample, root.BinaryName, root.BinaryName),
+		Run: func(_ *cobra.Command, _ []string) {
+			err := o.Run()
+			helper.CheckErr(err)
+		},
+	}
+
+	if o.Ctx == nil {
+		o.Ctx = cmd.Context()
+	}
+
+	cmd.Flags().StringVarP(&o.Namespace, "ns", "n", "", "the namespace to query the Terraform resources")
+	cmd.Flags().StringVarP(&o.Selector, "selector", "l", "kind="+terraforms.LabelValueKindTest, "the selector to find the test resources")
+	cmd.Flags().StringVarP(&o.Repository, "repo", "", "", "only list the tests of the given repository")
+	cmd.Flags().IntVarP(&o.PullRequestNumber, "pr", "", 0, "only list the tests of the given Pull Request number")
+	cmd.Flags().DurationVarP(&o.MinAge, "min-age", "", 0, "only list the tests older than the given duration")
+	cmd.Flags().DurationVarP(&o.MaxAge, "max-age", "", 0, "only list the tests younger than the given duration")
+	cmd.Flags().StringVarP(&o.OutputFormat, "output", "o", OutputFormatTable, "the output format: table, json or yaml")
+	cmd.Flags().StringVarP(&o.TerraformAPIVersion, "tf-api-version", "", "", "the version of the Terraform Operator API: v1alpha1 (tf.isaaguilar.com) or v1beta1 (tf.galleybytes.com). Detected from the cluster if not specified")
+	return cmd, o
+}
+
+// Run implements the command
+func (o *Options) Run() error {
+	err := o.Validate()
+	if err != nil {
+		return fmt.Errorf("failed to validate setup: %w", err)
+	}
+
+	o.Tests, err = o.ListTests(o.GetContext())
+	if err != nil {
+		return err
+	}
+	return o.printTests()
+}
+
+// ListTests lists the active tests matching the filters
+func (o *Options) ListTests(ctx context.Context) ([]*Test, error) {
+	selector := o.Selector
+	if o.Repository != "" {
+		selector = appendSelector(selector, "repo="+naming.ToValidName(o.Repository))
+	}
+	if o.PullRequestNumber > 0 {
+		selector = appendSelector(selector, "pr="+naming.ToValidName("PR-"+strconv.Itoa(o.PullRequestNumber)))
+	}
+
+	client := dynkube.DynamicResource(o.DynamicClient, o.Namespace, o.TerraformVersion.Resource)
+	list, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s resources in namespace %s with selector %s: %w", terraforms.TerraformKind, o.Namespace, selector, err)
+	}
+
+	stateOwners, err := o.stateOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var answer []*Test
+	for i := range list.Items {
+		r := &list.Items[i]
+		created := r.GetCreationTimestamp().Time
+		age := now.Sub(created)
+		if o.MinAge > 0 && age < o.MinAge {
+			continue
+		}
+		if o.MaxAge > 0 && age > o.MaxAge {
+			continue
+		}
+
+		name := r.GetName()
+		labels := r.GetLabels()
+		decision := policy.Evaluate(r, now)
+		test := &Test{
+			Name:       name,
+			Namespace:  r.GetNamespace(),
+			Owner:      labels["owner"],
+			Repository: labels["repo"],
+			PR:         labels["pr"],
+			Context:    labels["context"],
+			Build:      labels[terraforms.LabelBuild],
+			Created:    created,
+			Age:        duration.HumanDuration(age),
+			Keep:       decision.Keep,
+			State:      stateOwners[name],
+		}
+		if decision.Keep {
+			test.KeepReason = decision.Reason
+		}
+
+		jobList, err := terraforms.FindTerraformJobs(ctx, o.KubeClient, o.TerraformVersion, r.GetNamespace(), name)
+		if err != nil {
+			return nil, err
+		}
+		test.JobPhase = jobPhase(jobList)
+		answer = append(answer, test)
+	}
+	sort.Slice(answer, func(i, j int) bool {
+		return answer[i].Created.After(answer[j].Created)
+	})
+	return answer, nil
+}
+
+// stateOwners returns the names of the Terraform resources which have state
+func (o *Options) stateOwners(ctx context.Context) (map[string]bool, error) {
+	answer := map[string]bool{}
+	secretList, err := o.KubeClient.CoreV1().Secrets(o.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: terraforms.StateSelector,
+	})
+	if apierrors.IsNotFound(err) {
+		return answer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Terraform state Secrets in namespace %s: %w", o.Namespace, err)
+	}
+	for i := range secretList.Items {
+		owner := terraforms.StateOwnerName(&secretList.Items[i])
+		if owner != "" {
+			answer[owner] = true
+		}
+	}
+	return answer, nil
+}
+
+func (o *Options) printTests() error {
+	if o.OutputFormat != OutputFormatTable {
+		err := outputformat.Marshal(o.Tests, o.Out, o.OutputFormat)
+		if err != nil {
+			return fmt.Errorf("failed to output tests: %w", err)
+		}
+		_, err = fmt.Fprintln(o.Out)
+		return err
+	}
+	t := table.CreateTable(o.Out)
+	t.AddRow("NAME", "OWNER", "REPO", "PR", "CONTEXT", "BUILD", "AGE", "KEEP", "JOB", "STATE")
+	for _, test := range o.Tests {
+		t.AddRow(test.Name, test.Owner, test.Repository, test.PR, test.Context, test.Build, test.Age,
+			strconv.FormatBool(test.Keep), test.JobPhase, strconv.FormatBool(test.State))
+	}
+	t.Render()
+	return nil
+}
+
+// Validate validates the options and lazily creates the clients
+func (o *Options) Validate() error {
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	switch o.OutputFormat {
+	case "":
+		o.OutputFormat = OutputFormatTable
+	case OutputFormatTable, "json", "yaml":
+	default:
+		return options.InvalidOptionf("output", o.OutputFormat, "supported values are table, json or yaml")
+	}
+
+	var err error
+	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+	o.DynamicClient, err = kube.LazyCreateDynamicClient(o.DynamicClient)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	if o.TerraformVersion == nil {
+		o.TerraformVersion, err = terraforms.ResolveVersion(o.TerraformAPIVersion, o.KubeClient.Discovery())
+		if err != nil {
+			return options.InvalidOptionf("tf-api-version", o.TerraformAPIVersion, "%s", err.Error())
+		}
+	}
+	return nil
+}
+
+// GetContext lazily creates a context if it doesn't exist already
+func (o *Options) GetContext() context.Context {
+	if o.Ctx == nil {
+		o.Ctx = context.TODO()
+	}
+	return o.Ctx
+}
+
+// jobPhase returns the phase of the most recent Job
+func jobPhase(jobList []batchv1.Job) string {
+	if len(jobList) == 0 {
+		return JobPhaseNone
+	}
+	latest := &jobList[0]
+	for i := range jobList {
+		if jobList[i].CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = &jobList[i]
+		}
+	}
+	switch {
+	case !jobs.IsJobFinished(latest):
+		return JobPhaseRunning
+	case jobs.IsJobSucceeded(latest):
+		return JobPhaseSucceeded
+	default:
+		return JobPhaseFailed
+	}
+}
+
+func appendSelector(selector, requirement string) string {
+	if selector == "" {
+		return requirement
+	}
+	return selector + "," + requirement
+}
@@ -0,0 +1,174 @@
+package list_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/list"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	testResources = []string{
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-456
+    repo: myrepo
+    build: "1"
+  name: tf-myrepo-pr456-myctx-1
+  namespace: jx
+`,
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: myctx
+    owner: myowner
+    pr: pr-999
+    repo: myrepo
+    build: "3"
+    keep: "yes"
+  name: tf-myrepo-pr999-myctx-3
+  namespace: jx
+`,
+		`apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  labels:
+    kind: jx-test
+    context: other
+    owner: myowner
+    pr: pr-1
+    repo: another
+    build: "7"
+  name: tf-another-pr1-other-7
+  namespace: jx
+`,
+	}
+)
+
+func TestList(t *testing.T) {
+	ns := "jx"
+	now := time.Now()
+
+	fn := func(idx int, u *unstructured.Unstructured) {
+		u.SetCreationTimestamp(metav1.Time{Time: now.Add(time.Duration(-idx-1) * time.Hour)})
+	}
+	dynObjects := tftests.ParseUnstructureds(t, fn, testResources)
+
+	kubeClient := fake.NewSimpleClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "tf-myrepo-pr456-myctx-1", Namespace: ns},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "tf-myrepo-pr999-myctx-3", Namespace: ns},
+			Status: batchv1.JobStatus{
+				Succeeded: 1,
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+				},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "tfstate-default-tf-myrepo-pr999-myctx-3-state", Namespace: ns, Labels: map[string]string{"tfstate": "true"}},
+		},
+	)
+
+	testCases := []struct {
+		name     string
+		setup    func(o *list.Options)
+		expected []string
+	}{
+		{
+			name:     "all",
+			expected: []string{"tf-myrepo-pr456-myctx-1", "tf-myrepo-pr999-myctx-3", "tf-another-pr1-other-7"},
+		},
+		{
+			name: "repo",
+			setup: func(o *list.Options) {
+				o.Repository = "myrepo"
+			},
+			expected: []string{"tf-myrepo-pr456-myctx-1", "tf-myrepo-pr999-myctx-3"},
+		},
+		{
+			name: "pr",
+			setup: func(o *list.Options) {
+				o.PullRequestNumber = 999
+			},
+			expected: []string{"tf-myrepo-pr999-myctx-3"},
+		},
+		{
+			name: "age",
+			setup: func(o *list.Options) {
+				o.MinAge = 90 * time.Minute
+				o.MaxAge = 150 * time.Minute
+			},
+			expected: []string{"tf-myrepo-pr999-myctx-3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		out := &bytes.Buffer{}
+		_, o := list.NewCmdList()
+		o.Namespace = ns
+		o.OutputFormat = "json"
+		o.Out = out
+		o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+		o.KubeClient = kubeClient
+		if tc.setup != nil {
+			tc.setup(o)
+		}
+
+		err := o.Run()
+		require.NoError(t, err, "failed to run list command for %s", tc.name)
+
+		var tests []*list.Test
+		err = json.Unmarshal(out.Bytes(), &tests)
+		require.NoError(t, err, "failed to parse output for %s: %s", tc.name, out.String())
+
+		var names []string
+		for _, test := range tests {
+			names = append(names, test.Name)
+		}
+		assert.Equal(t, tc.expected, names, "tests for %s", tc.name)
+	}
+
+	_, o := list.NewCmdList()
+	o.Namespace = ns
+	o.Out = &bytes.Buffer{}
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+	o.KubeClient = kubeClient
+	err := o.Run()
+	require.NoError(t, err, "failed to run list command")
+	require.Len(t, o.Tests, 3, "tests")
+
+	running := o.Tests[0]
+	assert.Equal(t, "1", running.Build, "build of %s", running.Name)
+	assert.Equal(t, list.JobPhaseRunning, running.JobPhase, "job phase of %s", running.Name)
+	assert.False(t, running.Keep, "keep of %s", running.Name)
+	assert.False(t, running.State, "state of %s", running.Name)
+
+	kept := o.Tests[1]
+	assert.Equal(t, list.JobPhaseSucceeded, kept.JobPhase, "job phase of %s", kept.Name)
+	assert.True(t, kept.Keep, "keep of %s", kept.Name)
+	assert.True(t, kept.State, "state of %s", kept.Name)
+
+	assert.Equal(t, list.JobPhaseNone, o.Tests[2].JobPhase, "job phase of %s", o.Tests[2].Name)
+	assert.Contains(t, o.Out.(*bytes.Buffer).String(), "tf-another-pr1-other-7", "table output")
+}
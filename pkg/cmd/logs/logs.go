@@ -0,0 +1,235 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x-plugins/jx-test/pkg/jobwatch"
+	"github.com/jenkins-x-plugins/jx-test/pkg/root"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/helper"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras/templates"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/files"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/jobs"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/options"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/pipelinectx"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	info = termcolor.ColorInfo
+
+	cmdLong = templates.LongDesc(`
+		Displays the logs of the apply and destroy Jobs of a test
+`)
+
+	cmdExample = templates.Examples(`
+		%s logs --name tf-myrepo-pr123-pr-1 -f
+
+		# save the logs of the tests of a Pull Request to a directory
+		%s logs --repo myrepo --pr 123 --dir logs
+	`)
+)
+
+// Options the options for the command
+type Options struct {
+	pipelinectx.Options
+
+	Name                string
+	Namespace           string
+	Follow              bool
+	Dir                 string
+	PollPeriod          time.Duration
+	TerraformAPIVersion string
+	TerraformVersion    *terraforms.Version
+	KubeClient          kubernetes.Interface
+	DynamicClient       dynamic.Interface
+	Ctx                 context.Context
+	Out                 io.Writer
+}
+
+// NewCmdLogs creates a command object for the command
+func NewCmdLogs() (*cobra.Command, *Options) {
+	o := &Options{}
+
+	cmd := &cobra.Command{
+		Use:     "logs",
+		Aliases: []string{"log"},
+		Short:   "Displays the logs of the apply and destroy Jobs of a test",
+		Long:    cmdLong,
+		Example: fmt.Sprintf(cmdExample, root.BinaryName, root.BinaryName),
+		Run: func(_ *cobra.Command, _ []string) {
+			err := o.Run()
+			helper.CheckErr(err)
+		},
+	}
+
+	if o.Ctx == nil {
+		o.Ctx = cmd.Context()
+	}
+	err := o.EnvironmentDefaults(o.GetContext())
+	if err != nil {
+		log.Logger().Warnf("failed to default env vars: %s", err.Error())
+	}
+	// only filter by build if explicitly requested so that the logs of all the builds of the context are shown by default
+	o.BuildNumber = ""
+
+	o.Options.AddFlags(cmd)
+
+	cmd.Flags().StringVarP(&o.Name, "name", "", "", "the name of the test resource. If not specified the test resources matching the pipeline context are used")
+	cmd.Flags().StringVarP(&o.Namespace, "ns", "n", "", "the namespace of the test resources")
+	cmd.Flags().BoolVarP(&o.Follow, "follow", "f", false, "follows the logs until the Jobs complete")
+	cmd.Flags().StringVarP(&o.Dir, "dir", "", "", "the directory to save the logs of each pod container to")
+	cmd.Flags().DurationVarP(&o.PollPeriod, "poll-period", "", 5*time.Second, "how often to look for new pods when following the logs")
+	cmd.Flags().StringVarP(&o.TerraformAPIVersion, "tf-api-version", "", "", "the version of the Terraform Operator API: v1alpha1 (tf.isaaguilar.com) or v1beta1 (tf.galleybytes.com). Detected from the cluster if not specified")
+	return cmd, o
+}
+
+// Run implements the command
+func (o *Options) Run() error {
+	err := o.Validate()
+	if err != nil {
+		return fmt.Errorf("failed to validate: %w", err)
+	}
+
+	ctx := o.GetContext()
+	names, err := o.findNames(ctx)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		log.Logger().Infof("no test resources found in namespace %s", o.Namespace)
+		return nil
+	}
+
+	streamer := jobwatch.NewLogStreamer(o.KubeClient, o.Namespace, o.Out)
+	streamer.Follow = o.Follow
+	streamer.Prefix = true
+	streamer.Dir = o.Dir
+
+	for {
+		active, err := o.streamJobs(ctx, streamer, names)
+		if err != nil {
+			streamer.Wait()
+			return err
+		}
+		if !o.Follow || !active {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			streamer.Wait()
+			return ctx.Err()
+		case <-time.After(o.PollPeriod):
+		}
+	}
+	streamer.Wait()
+
+	if o.Dir != "" {
+		log.Logger().Infof("saved logs to %s", info(o.Dir))
+	}
+	return nil
+}
+
+// streamJobs streams the logs of the pods of the Jobs of the given tests returning true if any Job is still active
+func (o *Options) streamJobs(ctx context.Context, streamer *jobwatch.LogStreamer, names []string) (bool, error) {
+	active := false
+	for _, name := range names {
+		jobList, err := terraforms.ListTerraformJobs(ctx, o.KubeClient, o.TerraformVersion, o.Namespace, name)
+		if err != nil {
+			return false, err
+		}
+		for i := range jobList {
+			job := &jobList[i]
+			err = streamer.StreamPods(ctx, "job-name="+job.Name)
+			if err != nil {
+				return false, err
+			}
+			if !jobs.IsJobFinished(job) {
+				active = true
+			}
+		}
+	}
+	return active, nil
+}
+
+// findNames returns the names of the test resources
+func (o *Options) findNames(ctx context.Context) ([]string, error) {
+	if o.Name != "" {
+		return []string{o.Name}, nil
+	}
+
+	client := dynkube.DynamicResource(o.DynamicClient, o.Namespace, o.TerraformVersion.Resource)
+	selector := dynkube.ToSelector(o.Labels)
+	list, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s resources in namespace %s with selector %s: %w", terraforms.TerraformKind, o.Namespace, selector, err)
+	}
+	var answer []string
+	for i := range list.Items {
+		answer = append(answer, list.Items[i].GetName())
+	}
+	return answer, nil
+}
+
+// Validate validates the options, builds the selector labels and lazily creates the clients
+func (o *Options) Validate() error {
+	if o.Name == "" {
+		o.Labels = terraforms.PipelineLabels(&o.Options)
+		if len(o.Labels) == 1 {
+			return options.MissingOption("name")
+		}
+	}
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	if o.Dir != "" {
+		err := os.MkdirAll(o.Dir, files.DefaultDirWritePermissions)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", o.Dir, err)
+		}
+	}
+
+	var err error
+	o.KubeClient, o.Namespace, err = kube.LazyCreateKubeClientAndNamespace(o.KubeClient, o.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+	o.DynamicClient, err = kube.LazyCreateDynamicClient(o.DynamicClient)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	if o.TerraformVersion == nil {
+		o.TerraformVersion, err = terraforms.ResolveVersion(o.TerraformAPIVersion, o.KubeClient.Discovery())
+		if err != nil {
+			return options.InvalidOptionf("tf-api-version", o.TerraformAPIVersion, "%s", err.Error())
+		}
+	}
+	return nil
+}
+
+// GetContext lazily creates a context if it doesn't exist already
+func (o *Options) GetContext() context.Context {
+	if o.Ctx == nil {
+		o.Ctx = context.TODO()
+	}
+	return o.Ctx
+}
@@ -0,0 +1,88 @@
+package logs_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/logs"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogs(t *testing.T) {
+	ns := "jx"
+	name := "tf-myrepo-pr456-myctx-1"
+	dir := filepath.Join(t.TempDir(), "logs")
+
+	out := &bytes.Buffer{}
+	_, o := logs.NewCmdLogs()
+	o.Namespace = ns
+	o.Name = name
+	o.Dir = dir
+	o.Out = out
+	o.DynamicClient = tftests.NewFakeDynClient(runtime.NewScheme())
+	o.KubeClient = fake.NewSimpleClientset(
+		newCompletedJob(name, ns),
+		newCompletedJob(name+"-destroy", ns),
+		newCompletedJob("tf-another-pr1-ctx-2", ns),
+		newPod(name+"-abc", name, ns, 0),
+		newPod(name+"-destroy-def", name+"-destroy", ns, 1),
+		newPod("tf-another-pr1-ctx-2-ghi", "tf-another-pr1-ctx-2", ns, 0),
+	)
+
+	err := o.Run()
+	require.NoError(t, err, "failed to run logs command")
+
+	output := out.String()
+	assert.Contains(t, output, name+"-abc/terraform: fake logs", "apply logs")
+	assert.Contains(t, output, name+"-destroy-def/terraform: fake logs", "destroy logs")
+	assert.NotContains(t, output, "tf-another-pr1-ctx-2-ghi", "should not show the logs of other tests")
+
+	for _, podName := range []string{name + "-abc", name + "-destroy-def"} {
+		path := filepath.Join(dir, podName+"_terraform.log")
+		data, err := os.ReadFile(path)
+		require.NoError(t, err, "failed to read %s", path)
+		assert.Equal(t, "fake logs\n", string(data), "saved logs of %s", podName)
+	}
+}
+
+func newCompletedJob(name, ns string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Status: batchv1.JobStatus{
+			Succeeded: 1,
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func newPod(name, jobName, ns string, restartCount int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    map[string]string{"job-name": jobName},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:         "terraform",
+					RestartCount: restartCount,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{},
+					},
+				},
+			},
+		},
+	}
+}
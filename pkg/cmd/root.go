@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/create"
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/delete"
 	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/gc"
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/list"
+	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/logs"
 	"github.com/jenkins-x-plugins/jx-test/pkg/cmd/version"
 	"github.com/jenkins-x-plugins/jx-test/pkg/root"
 	"github.com/jenkins-x/jx-helpers/v3/pkg/cobras"
@@ -23,7 +26,10 @@ func Main() *cobra.Command {
 		},
 	}
 	cmd.AddCommand(cobras.SplitCommand(create.NewCmdCreate()))
+	cmd.AddCommand(cobras.SplitCommand(delete.NewCmdDelete()))
 	cmd.AddCommand(cobras.SplitCommand(gc.NewCmdGC()))
+	cmd.AddCommand(cobras.SplitCommand(list.NewCmdList()))
+	cmd.AddCommand(cobras.SplitCommand(logs.NewCmdLogs()))
 	cmd.AddCommand(cobras.SplitCommand(version.NewCmdVersion()))
 	return cmd
 }
@@ -1,6 +1,7 @@
 package dynkube
 
 import (
+	"sort"
 	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -18,19 +19,25 @@ func DynamicResource(dynamicClient dynamic.Interface, ns string, gvr schema.Grou
 	return client
 }
 
-// ToSelector converts the given labels into a selector string
+// ToSelector converts the given labels into a selector string with the labels in key order
 func ToSelector(labels map[string]string) string {
 	if labels == nil {
 		return ""
 	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	buf := &strings.Builder{}
-	for k, v := range labels {
+	for _, k := range keys {
 		if buf.Len() > 0 {
 			buf.WriteString(",")
 		}
 		buf.WriteString(k)
 		buf.WriteString("=")
-		buf.WriteString(v)
+		buf.WriteString(labels[k])
 	}
 	return buf.String()
 }
@@ -0,0 +1,77 @@
+package dynkube
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/restmapper"
+)
+
+// StaticMapping a static mapping of a kind to its resource which is used if discovery cannot find the kind
+type StaticMapping struct {
+	// GroupVersionKind the kind being mapped
+	GroupVersionKind schema.GroupVersionKind
+
+	// Resource the plural resource name. If not specified it is guessed from the kind
+	Resource string
+
+	// ClusterScoped whether the resource is cluster scoped rather than namespaced
+	ClusterScoped bool
+}
+
+// NewStaticRESTMapper creates a RESTMapper from the given static mappings
+func NewStaticRESTMapper(mappings ...StaticMapping) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, m := range mappings {
+		scope := meta.RESTScopeNamespace
+		if m.ClusterScoped {
+			scope = meta.RESTScopeRoot
+		}
+		if m.Resource == "" {
+			mapper.Add(m.GroupVersionKind, scope)
+			continue
+		}
+		plural := m.GroupVersionKind.GroupVersion().WithResource(m.Resource)
+		// like meta.UnsafeGuessKindToResource the singular resource is the lower case kind
+		singular := m.GroupVersionKind.GroupVersion().WithResource(strings.ToLower(m.GroupVersionKind.Kind))
+		mapper.AddSpecific(m.GroupVersionKind, plural, singular, scope)
+	}
+	return mapper
+}
+
+// NewRESTMapper creates a RESTMapper which resolves kinds via discovery, using the optional fallback
+// mapper for any kinds discovery cannot find
+func NewRESTMapper(discoveryClient discovery.DiscoveryInterface, fallback meta.RESTMapper) meta.RESTMapper {
+	var mappers meta.MultiRESTMapper
+	if discoveryClient != nil {
+		groupResources, err := restmapper.GetAPIGroupResources(discoveryClient)
+		if err != nil {
+			log.Logger().Warnf("failed to discover all API resources: %s", err.Error())
+		}
+		if len(groupResources) > 0 {
+			mappers = append(mappers, restmapper.NewDiscoveryRESTMapper(groupResources))
+		}
+	}
+	if fallback != nil {
+		mappers = append(mappers, fallback)
+	}
+	return meta.FirstHitRESTMapper{MultiRESTMapper: mappers}
+}
+
+// ResourceMapping resolves the resource for the given kind and whether it is namespaced
+func ResourceMapping(mapper meta.RESTMapper, gvk schema.GroupVersionKind) (schema.GroupVersionResource, bool, error) {
+	if mapper == nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("no RESTMapper")
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("failed to find resource for %s: %w", gvk.String(), err)
+	}
+	namespaced := mapping.Scope == nil || mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	return mapping.Resource, namespaced, nil
+}
@@ -0,0 +1,52 @@
+package dynkube_test
+
+import (
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResourceMapping(t *testing.T) {
+	policy := schema.GroupVersionKind{Group: "policy.example.com", Version: "v1", Kind: "Policy"}
+	ingress := schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	clusterRole := schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}
+	cheese := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Cheese"}
+
+	fallback := dynkube.NewStaticRESTMapper(
+		dynkube.StaticMapping{GroupVersionKind: policy},
+		dynkube.StaticMapping{GroupVersionKind: ingress},
+		dynkube.StaticMapping{GroupVersionKind: clusterRole, ClusterScoped: true},
+		dynkube.StaticMapping{GroupVersionKind: cheese, Resource: "cheeses"},
+	)
+	mapper := dynkube.NewRESTMapper(fake.NewSimpleClientset().Discovery(), fallback)
+
+	testCases := []struct {
+		gvk        schema.GroupVersionKind
+		resource   string
+		namespaced bool
+	}{
+		{gvk: policy, resource: "policies", namespaced: true},
+		{gvk: ingress, resource: "ingresses", namespaced: true},
+		{gvk: clusterRole, resource: "clusterroles", namespaced: false},
+		{gvk: cheese, resource: "cheeses", namespaced: true},
+	}
+	for _, tc := range testCases {
+		gvr, namespaced, err := dynkube.ResourceMapping(mapper, tc.gvk)
+		require.NoError(t, err, "failed to resolve %s", tc.gvk.String())
+		assert.Equal(t, tc.gvk.GroupVersion().WithResource(tc.resource), gvr, "resource for %s", tc.gvk.String())
+		assert.Equal(t, tc.namespaced, namespaced, "namespaced for %s", tc.gvk.String())
+	}
+
+	// the singular resource of an explicit mapping is the lower case kind
+	gvk, err := fallback.KindFor(cheese.GroupVersion().WithResource("cheese"))
+	require.NoError(t, err, "failed to resolve the singular cheese resource")
+	assert.Equal(t, cheese, gvk, "kind for the singular cheese resource")
+
+	_, _, err = dynkube.ResourceMapping(mapper, schema.GroupVersionKind{Group: "unknown.com", Version: "v1", Kind: "Unknown"})
+	require.Error(t, err, "should have failed to resolve an unknown kind")
+}
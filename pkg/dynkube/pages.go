@@ -0,0 +1,42 @@
+package dynkube
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultPageSize the default maximum number of resources fetched by each List request
+const DefaultPageSize int64 = 500
+
+// List a page of resources returned by a List request such as a *corev1.SecretList or *unstructured.UnstructuredList
+type List interface {
+	GetContinue() string
+}
+
+// ListFunc lists a page of resources such as the List method of a typed or dynamic client
+type ListFunc[L List] func(ctx context.Context, opts metav1.ListOptions) (L, error)
+
+// ListPages lists the resources a page at a time following the Continue tokens so that large collections
+// are never held in memory at once. The page size defaults to DefaultPageSize if the options have no Limit.
+// Iteration stops at the first error returned by the list function or the callback
+func ListPages[L List](ctx context.Context, listFn ListFunc[L], listOptions metav1.ListOptions, fn func(L) error) error {
+	if listOptions.Limit <= 0 {
+		listOptions.Limit = DefaultPageSize
+	}
+	listOptions.Continue = ""
+	for {
+		list, err := listFn(ctx, listOptions)
+		if err != nil {
+			return err
+		}
+		err = fn(list)
+		if err != nil {
+			return err
+		}
+		listOptions.Continue = list.GetContinue()
+		if listOptions.Continue == "" {
+			return nil
+		}
+	}
+}
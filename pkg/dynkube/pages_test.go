@@ -0,0 +1,118 @@
+package dynkube_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// pageReactor returns the list of the tracker a page at a time using the offset of the next page as the continue token
+func pageReactor(t *testing.T, tracker clienttesting.ObjectTracker, gvk schema.GroupVersionKind, requests *[]metav1.ListOptions) clienttesting.ReactionFunc {
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		listAction := action.(clienttesting.ListActionImpl)
+		listOptions := listAction.ListOptions
+		*requests = append(*requests, listOptions)
+
+		obj, err := tracker.List(listAction.GetResource(), gvk, listAction.GetNamespace())
+		require.NoError(t, err, "failed to list %s", gvk.Kind)
+		items, err := meta.ExtractList(obj)
+		require.NoError(t, err, "failed to extract %s items", gvk.Kind)
+
+		start := 0
+		if listOptions.Continue != "" {
+			start, err = strconv.Atoi(listOptions.Continue)
+			require.NoError(t, err, "failed to parse continue token")
+		}
+		end := min(start+int(listOptions.Limit), len(items))
+		err = meta.SetList(obj, items[start:end])
+		require.NoError(t, err, "failed to set %s items", gvk.Kind)
+		if end < len(items) {
+			listMeta, err := meta.ListAccessor(obj)
+			require.NoError(t, err, "failed to access %s list", gvk.Kind)
+			listMeta.SetContinue(strconv.Itoa(end))
+		}
+		return true, obj, nil
+	}
+}
+
+func TestListPages(t *testing.T) {
+	ns := "jx"
+	var objects []runtime.Object
+	for i := 0; i < 5; i++ {
+		objects = append(objects, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cm-%d", i), Namespace: ns}})
+	}
+	kubeClient := fake.NewSimpleClientset(objects...)
+	var requests []metav1.ListOptions
+	kubeClient.PrependReactor("list", "configmaps", pageReactor(t, kubeClient.Tracker(), corev1.SchemeGroupVersion.WithKind("ConfigMap"), &requests))
+
+	ctx := context.TODO()
+	var names []string
+	pages := 0
+	err := dynkube.ListPages(ctx, kubeClient.CoreV1().ConfigMaps(ns).List, metav1.ListOptions{Limit: 2}, func(list *corev1.ConfigMapList) error {
+		pages++
+		assert.LessOrEqual(t, len(list.Items), 2, "items in page %d", pages)
+		for i := range list.Items {
+			names = append(names, list.Items[i].Name)
+		}
+		return nil
+	})
+	require.NoError(t, err, "failed to list pages")
+	assert.Equal(t, 3, pages, "pages")
+	assert.ElementsMatch(t, []string{"cm-0", "cm-1", "cm-2", "cm-3", "cm-4"}, names, "names")
+	require.Len(t, requests, 3, "requests")
+	assert.Equal(t, "", requests[0].Continue, "continue token of first request")
+	assert.Equal(t, "2", requests[1].Continue, "continue token of second request")
+	assert.Equal(t, "4", requests[2].Continue, "continue token of third request")
+
+	// lets check the default page size and that an error stops the iteration
+	requests = nil
+	stop := errors.New("stop")
+	err = dynkube.ListPages(ctx, kubeClient.CoreV1().ConfigMaps(ns).List, metav1.ListOptions{}, func(_ *corev1.ConfigMapList) error {
+		return stop
+	})
+	require.ErrorIs(t, err, stop, "should return the error of the callback")
+	require.Len(t, requests, 1, "should stop after the first page")
+	assert.Equal(t, dynkube.DefaultPageSize, requests[0].Limit, "default page size")
+}
+
+func TestListPagesDynamic(t *testing.T) {
+	ns := "jx"
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	var objects []runtime.Object
+	for i := 0; i < 3; i++ {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("v1")
+		u.SetKind("ConfigMap")
+		u.SetName(fmt.Sprintf("cm-%d", i))
+		u.SetNamespace(ns)
+		objects = append(objects, u)
+	}
+	dynClient := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "ConfigMapList"}, objects...)
+
+	// the fake dynamic client does not pass the list options to reactors so all items are returned in one page
+	var names []string
+	err := dynkube.ListPages(context.TODO(), dynClient.Resource(gvr).Namespace(ns).List, metav1.ListOptions{Limit: 1}, func(list *unstructured.UnstructuredList) error {
+		for i := range list.Items {
+			names = append(names, list.Items[i].GetName())
+		}
+		return nil
+	})
+	require.NoError(t, err, "failed to list pages")
+	assert.ElementsMatch(t, []string{"cm-0", "cm-1", "cm-2"}, names, "names")
+}
@@ -0,0 +1,156 @@
+package jobwatch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/files"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LogStreamer streams the logs of the containers of Pods to an output writer
+// making sure each run of a container is only streamed once
+type LogStreamer struct {
+	KubeClient kubernetes.Interface
+	Namespace  string
+	Out        io.Writer
+
+	// Follow follows the logs of running containers until they terminate
+	Follow bool
+
+	// Prefix prefixes each line written to Out with the pod and container name
+	Prefix bool
+
+	// Dir if specified the logs of each container are also appended to a file in this directory
+	Dir string
+
+	lock          sync.Mutex
+	wg            sync.WaitGroup
+	streamed      map[string]bool
+	applyComplete bool
+}
+
+// NewLogStreamer creates a new log streamer
+func NewLogStreamer(kubeClient kubernetes.Interface, ns string, out io.Writer) *LogStreamer {
+	return &LogStreamer{
+		KubeClient: kubeClient,
+		Namespace:  ns,
+		Out:        out,
+		Follow:     true,
+		streamed:   map[string]bool{},
+	}
+}
+
+// StreamPods starts streaming the logs of any started containers of the Pods matching the selector
+// which have not yet been streamed
+func (s *LogStreamer) StreamPods(ctx context.Context, selector string) error {
+	podList, err := s.KubeClient.CoreV1().Pods(s.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list Pods in namespace %s with selector %s: %w", s.Namespace, selector, err)
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		for j := range pod.Status.ContainerStatuses {
+			status := &pod.Status.ContainerStatuses[j]
+			if status.State.Running == nil && status.State.Terminated == nil {
+				continue
+			}
+			key := fmt.Sprintf("%s/%s/%d", pod.Name, status.Name, status.RestartCount)
+			if s.markStreamed(key) {
+				continue
+			}
+			s.wg.Add(1)
+			go func(podName, containerName string) {
+				defer s.wg.Done()
+				err := s.streamContainer(ctx, podName, containerName)
+				if err != nil {
+					log.Logger().Warnf("failed to stream logs of pod %s container %s: %s", podName, containerName, err.Error())
+				}
+			}(pod.Name, status.Name)
+		}
+	}
+	return nil
+}
+
+// Wait waits for all of the active log streams to complete
+func (s *LogStreamer) Wait() {
+	s.wg.Wait()
+}
+
+// ApplyCompleted returns true if any of the log output streamed so far contains ApplyCompleteMarker
+func (s *LogStreamer) ApplyCompleted() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.applyComplete
+}
+
+func (s *LogStreamer) markStreamed(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.streamed[key] {
+		return true
+	}
+	s.streamed[key] = true
+	return false
+}
+
+func (s *LogStreamer) streamContainer(ctx context.Context, podName, containerName string) error {
+	req := s.KubeClient.CoreV1().Pods(s.Namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    s.Follow,
+	})
+	reader, err := req.Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open log stream: %w", err)
+	}
+	defer reader.Close()
+
+	var file io.Writer
+	if s.Dir != "" {
+		path := filepath.Join(s.Dir, podName+"_"+containerName+".log")
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, files.DefaultFileWritePermissions)
+		if err != nil {
+			return fmt.Errorf("failed to open log file %s: %w", path, err)
+		}
+		defer f.Close()
+		file = f
+	}
+
+	prefix := ""
+	if s.Prefix {
+		prefix = podName + "/" + containerName + ": "
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.writeLine(prefix, line)
+		if file != nil {
+			_, err = fmt.Fprintln(file, line)
+			if err != nil {
+				return fmt.Errorf("failed to save logs of pod %s container %s: %w", podName, containerName, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *LogStreamer) writeLine(prefix, line string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if strings.Contains(line, ApplyCompleteMarker) {
+		s.applyComplete = true
+	}
+	fmt.Fprintln(s.Out, prefix+line)
+}
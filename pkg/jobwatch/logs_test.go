@@ -0,0 +1,60 @@
+package jobwatch_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/jobwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogStreamerRestarts(t *testing.T) {
+	ns := "jx"
+	ctx := context.TODO()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mypod",
+			Namespace: ns,
+			Labels:    map[string]string{"job-name": "myjob"},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "terraform",
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{},
+					},
+				},
+			},
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(pod)
+
+	out := &bytes.Buffer{}
+	s := jobwatch.NewLogStreamer(kubeClient, ns, out)
+	s.Prefix = true
+
+	// streaming again without a restart should not duplicate the logs
+	for i := 0; i < 2; i++ {
+		err := s.StreamPods(ctx, "job-name=myjob")
+		require.NoError(t, err, "failed to stream pods")
+		s.Wait()
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), "mypod/terraform: fake logs"), "logs before restart")
+
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	_, err := kubeClient.CoreV1().Pods(ns).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err, "failed to update pod")
+
+	err = s.StreamPods(ctx, "job-name=myjob")
+	require.NoError(t, err, "failed to stream pods")
+	s.Wait()
+	assert.Equal(t, 2, strings.Count(out.String(), "mypod/terraform: fake logs"), "logs after restart")
+	assert.False(t, s.ApplyCompleted(), "should not have seen the apply complete marker")
+}
@@ -0,0 +1,282 @@
+package jobwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/jobs"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// Outcome the outcome of watching a Job
+type Outcome string
+
+const (
+	// OutcomeSucceeded the Job completed successfully
+	OutcomeSucceeded Outcome = "succeeded"
+
+	// OutcomeFailed the Job failed or its output could not be verified
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeTimedOut the Job did not finish before the timeout
+	OutcomeTimedOut Outcome = "timed-out"
+
+	// OutcomeDeleted the Job was deleted before it finished
+	OutcomeDeleted Outcome = "deleted"
+
+	// ApplyCompleteMarker the text terraform logs when an apply succeeds which is used to verify the result
+	ApplyCompleteMarker = "Apply complete!"
+
+	defaultTimeout    = time.Hour
+	defaultPollPeriod = 2 * time.Second
+)
+
+var (
+	info = termcolor.ColorInfo
+)
+
+// Result the result of watching a Job
+type Result struct {
+	// Outcome the outcome of the Job
+	Outcome Outcome
+
+	// Message a human readable description of the outcome
+	Message string
+
+	// Job the last known state of the Job if it was found
+	Job *batchv1.Job
+}
+
+// Succeeded returns true if the Job completed successfully
+func (r *Result) Succeeded() bool {
+	return r != nil && r.Outcome == OutcomeSucceeded
+}
+
+// Err returns an error describing the result if the Job did not succeed
+func (r *Result) Err() error {
+	if r == nil {
+		return fmt.Errorf("no result")
+	}
+	if r.Succeeded() {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", r.Outcome, r.Message)
+}
+
+// Options the options for watching a Job
+type Options struct {
+	KubeClient   kubernetes.Interface
+	Namespace    string
+	Name         string
+	Timeout      time.Duration
+	PollPeriod   time.Duration
+	VerifyResult bool
+	Out          io.Writer
+
+	// JobSelector if not empty the label selector of the Jobs to watch instead of the Job called Name. Used for
+	// operators which name their Jobs differently from the resource they run
+	JobSelector string
+
+	// PodSelector the label selector of the Pods whose logs are streamed. Defaults to the Pods of the Job called Name
+	PodSelector string
+
+	// PreviousUIDs the UIDs of the Jobs of a previous run which are ignored until they are replaced
+	PreviousUIDs []types.UID
+}
+
+// Watch waits for the Job to complete, fail, be deleted or for the timeout to expire while
+// streaming the logs of its Pods
+func (o *Options) Watch(ctx context.Context) (*Result, error) {
+	if o.KubeClient == nil {
+		return nil, fmt.Errorf("no KubeClient")
+	}
+	if o.Name == "" {
+		return nil, fmt.Errorf("no Job name")
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.PollPeriod <= 0 {
+		o.PollPeriod = defaultPollPeriod
+	}
+	if o.Out == nil {
+		o.Out = os.Stdout
+	}
+	if o.PodSelector == "" {
+		o.PodSelector = "job-name=" + o.Name
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	streamer := NewLogStreamer(o.KubeClient, o.Namespace, o.Out)
+	jobInterface := o.KubeClient.BatchV1().Jobs(o.Namespace)
+	ticker := time.NewTicker(o.PollPeriod)
+	defer ticker.Stop()
+
+	log.Logger().Infof("waiting for Job %s in namespace %s to complete", info(o.Name), info(o.Namespace))
+
+	var lastJob *batchv1.Job
+	for {
+		job, err := o.findJob(watchCtx, jobInterface)
+		switch {
+		case err != nil && watchCtx.Err() == nil:
+			return nil, err
+		case err != nil:
+		case job != nil:
+			lastJob = job
+			err = streamer.StreamPods(watchCtx, o.PodSelector)
+			if err != nil {
+				log.Logger().Warnf("failed to stream logs of Job %s: %s", job.Name, err.Error())
+			}
+			if jobs.IsJobFinished(job) {
+				return o.finished(ctx, streamer, job), nil
+			}
+		case lastJob != nil:
+			streamer.Wait()
+			return &Result{
+				Outcome: OutcomeDeleted,
+				Message: fmt.Sprintf("Job %s was deleted before it finished", lastJob.Name),
+				Job:     lastJob,
+			}, nil
+		default:
+			log.Logger().Debugf("Job %s in namespace %s does not exist yet or has not been replaced yet", o.Name, o.Namespace)
+		}
+
+		select {
+		case <-watchCtx.Done():
+			streamer.Wait()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return &Result{
+				Outcome: OutcomeTimedOut,
+				Message: fmt.Sprintf("Job %s did not finish within %s", o.Name, o.Timeout.String()),
+				Job:     lastJob,
+			}, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// findJob returns the Job which decides the outcome or nil if it does not exist yet. If there is a JobSelector
+// this is the first failed Job, else the first active Job, else the newest Job ignoring those of a previous run
+func (o *Options) findJob(ctx context.Context, jobInterface batchv1client.JobInterface) (*batchv1.Job, error) {
+	if o.JobSelector == "" {
+		job, err := jobInterface.Get(ctx, o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Job %s in namespace %s: %w", o.Name, o.Namespace, err)
+		}
+		if o.isPrevious(job) {
+			return nil, nil
+		}
+		return job, nil
+	}
+
+	list, err := jobInterface.List(ctx, metav1.ListOptions{LabelSelector: o.JobSelector})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Jobs in namespace %s with selector %s: %w", o.Namespace, o.JobSelector, err)
+	}
+	var failed, active, newest *batchv1.Job
+	for i := range list.Items {
+		job := &list.Items[i]
+		switch {
+		case o.isPrevious(job):
+			continue
+		case jobs.IsJobFinished(job) && !jobs.IsJobSucceeded(job):
+			if failed == nil {
+				failed = job
+			}
+		case !jobs.IsJobFinished(job):
+			if active == nil {
+				active = job
+			}
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&job.CreationTimestamp) {
+			newest = job
+		}
+	}
+	switch {
+	case failed != nil:
+		return failed, nil
+	case active != nil:
+		return active, nil
+	default:
+		return newest, nil
+	}
+}
+
+func (o *Options) isPrevious(job *batchv1.Job) bool {
+	for _, uid := range o.PreviousUIDs {
+		if job.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// finished streams any remaining logs for a finished Job and determines its outcome
+func (o *Options) finished(ctx context.Context, streamer *LogStreamer, job *batchv1.Job) *Result {
+	// lets make sure we capture the logs of any pods which completed between polls
+	err := streamer.StreamPods(ctx, o.PodSelector)
+	if err != nil {
+		log.Logger().Warnf("failed to stream logs of Job %s: %s", job.Name, err.Error())
+	}
+	streamer.Wait()
+
+	if !jobs.IsJobSucceeded(job) {
+		return &Result{
+			Outcome: OutcomeFailed,
+			Message: fmt.Sprintf("Job %s failed: %s", job.Name, failureMessage(job)),
+			Job:     job,
+		}
+	}
+	if o.VerifyResult && !streamer.ApplyCompleted() {
+		return &Result{
+			Outcome: OutcomeFailed,
+			Message: fmt.Sprintf("Job %s completed but its logs do not contain %q", job.Name, ApplyCompleteMarker),
+			Job:     job,
+		}
+	}
+	return &Result{
+		Outcome: OutcomeSucceeded,
+		Message: fmt.Sprintf("Job %s succeeded", job.Name),
+		Job:     job,
+	}
+}
+
+func failureMessage(job *batchv1.Job) string {
+	for _, con := range job.Status.Conditions {
+		if con.Status != corev1.ConditionTrue {
+			continue
+		}
+		if con.Type == batchv1.JobFailed || con.Type == batchv1.JobSuspended {
+			if con.Message != "" {
+				return con.Message
+			}
+			if con.Reason != "" {
+				return con.Reason
+			}
+			return string(con.Type)
+		}
+	}
+	return "unknown reason"
+}
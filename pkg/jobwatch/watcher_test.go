@@ -0,0 +1,222 @@
+package jobwatch_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/jobwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	ns      = "jx"
+	jobName = "tf-myrepo-pr456-myctx-3"
+)
+
+func TestWatchJob(t *testing.T) {
+	testCases := []struct {
+		name         string
+		conditions   []batchv1.JobCondition
+		verifyResult bool
+		expected     jobwatch.Outcome
+	}{
+		{
+			name:       "succeeded",
+			conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			expected:   jobwatch.OutcomeSucceeded,
+		},
+		{
+			name:       "failed",
+			conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}},
+			expected:   jobwatch.OutcomeFailed,
+		},
+		{
+			name:         "unverified",
+			conditions:   []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			verifyResult: true,
+			expected:     jobwatch.OutcomeFailed,
+		},
+		{
+			name:     "timed-out",
+			expected: jobwatch.OutcomeTimedOut,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			kubeClient := fake.NewSimpleClientset(newJob(tc.conditions), newPod())
+
+			w := &jobwatch.Options{
+				KubeClient:   kubeClient,
+				Namespace:    ns,
+				Name:         jobName,
+				Timeout:      100 * time.Millisecond,
+				PollPeriod:   time.Millisecond,
+				VerifyResult: tc.verifyResult,
+				Out:          out,
+			}
+			result, err := w.Watch(context.TODO())
+			require.NoError(t, err, "failed to watch job")
+			require.NotNil(t, result, "no result")
+			assert.Equal(t, tc.expected, result.Outcome, "result.Outcome for %s", tc.name)
+			assert.Contains(t, out.String(), "fake logs", "should have streamed the pod logs")
+			t.Logf("%s: %s\n", result.Outcome, result.Message)
+		})
+	}
+}
+
+func TestWatchDeletedJob(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(newJob(nil))
+	ctx := context.TODO()
+
+	w := &jobwatch.Options{
+		KubeClient: kubeClient,
+		Namespace:  ns,
+		Name:       jobName,
+		Timeout:    time.Minute,
+		PollPeriod: time.Millisecond,
+		Out:        &bytes.Buffer{},
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		err := kubeClient.BatchV1().Jobs(ns).Delete(ctx, jobName, metav1.DeleteOptions{})
+		assert.NoError(t, err, "failed to delete job")
+	}()
+
+	result, err := w.Watch(ctx)
+	require.NoError(t, err, "failed to watch job")
+	assert.Equal(t, jobwatch.OutcomeDeleted, result.Outcome, "result.Outcome")
+}
+
+func TestWatchReplacedJob(t *testing.T) {
+	previous := newJob([]batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}).(*batchv1.Job)
+	previous.UID = "previous"
+	kubeClient := fake.NewSimpleClientset(previous)
+	ctx := context.TODO()
+
+	w := &jobwatch.Options{
+		KubeClient:   kubeClient,
+		Namespace:    ns,
+		Name:         jobName,
+		Timeout:      time.Minute,
+		PollPeriod:   time.Millisecond,
+		Out:          &bytes.Buffer{},
+		PreviousUIDs: []types.UID{previous.UID},
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		err := kubeClient.BatchV1().Jobs(ns).Delete(ctx, jobName, metav1.DeleteOptions{})
+		assert.NoError(t, err, "failed to delete previous job")
+
+		job := newJob([]batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}).(*batchv1.Job)
+		job.UID = "replacement"
+		_, err = kubeClient.BatchV1().Jobs(ns).Create(ctx, job, metav1.CreateOptions{})
+		assert.NoError(t, err, "failed to create replacement job")
+	}()
+
+	result, err := w.Watch(ctx)
+	require.NoError(t, err, "failed to watch job")
+	assert.Equal(t, jobwatch.OutcomeFailed, result.Outcome, "should ignore the previous job")
+	require.NotNil(t, result.Job, "result.Job")
+	assert.Equal(t, "replacement", string(result.Job.UID), "result.Job.UID")
+}
+
+func TestWatchJobsBySelector(t *testing.T) {
+	const label = "terraforms.tf.galleybytes.com/resourceName"
+	completed := []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+
+	previous := newJob(completed).(*batchv1.Job)
+	previous.Name = jobName + "-1-apply"
+	previous.UID = "previous"
+	previous.Labels = map[string]string{label: jobName}
+
+	setup := newJob(completed).(*batchv1.Job)
+	setup.Name = jobName + "-2-setup"
+	setup.UID = "setup"
+	setup.Labels = map[string]string{label: jobName}
+
+	apply := newJob(nil).(*batchv1.Job)
+	apply.Name = jobName + "-2-apply"
+	apply.UID = "apply"
+	apply.Labels = map[string]string{label: jobName}
+
+	pod := newPod().(*corev1.Pod)
+	pod.Labels = map[string]string{label: jobName}
+
+	kubeClient := fake.NewSimpleClientset(previous, setup, apply, pod)
+	ctx := context.TODO()
+	out := &bytes.Buffer{}
+
+	w := &jobwatch.Options{
+		KubeClient:   kubeClient,
+		Namespace:    ns,
+		Name:         jobName,
+		JobSelector:  label + "=" + jobName,
+		PodSelector:  label + "=" + jobName,
+		Timeout:      time.Minute,
+		PollPeriod:   time.Millisecond,
+		Out:          out,
+		PreviousUIDs: []types.UID{previous.UID},
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		apply.Status.Conditions = completed
+		_, err := kubeClient.BatchV1().Jobs(ns).Update(ctx, apply, metav1.UpdateOptions{})
+		assert.NoError(t, err, "failed to complete apply job")
+	}()
+
+	result, err := w.Watch(ctx)
+	require.NoError(t, err, "failed to watch jobs")
+	assert.Equal(t, jobwatch.OutcomeSucceeded, result.Outcome, "result.Outcome")
+	require.NotNil(t, result.Job, "result.Job")
+	assert.Equal(t, apply.Name, result.Job.Name, "should wait for the active job")
+	assert.Contains(t, out.String(), "fake logs", "should have streamed the pod logs selected by label")
+}
+
+func newJob(conditions []batchv1.JobCondition) runtime.Object {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: ns,
+		},
+		Status: batchv1.JobStatus{
+			Conditions: conditions,
+		},
+	}
+}
+
+func newPod() runtime.Object {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName + "-abcde",
+			Namespace: ns,
+			Labels: map[string]string{
+				"job-name": jobName,
+			},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "terraform",
+					State: corev1.ContainerState{
+						Running: &corev1.ContainerStateRunning{},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,459 @@
+package locks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// AnnotationBuild the annotation on the Lease recording the build number of the holder
+	AnnotationBuild = "jx-test.jenkins-x.io/build"
+
+	// AnnotationSelector the annotation on the Lease recording the selector of the test resources it guards
+	AnnotationSelector = "jx-test.jenkins-x.io/selector"
+
+	// AnnotationPreemptedBy the annotation on the Lease recording the build number of a newer build waiting for the
+	// holder to stop and release the lock
+	AnnotationPreemptedBy = "jx-test.jenkins-x.io/preempted-by"
+
+	// DefaultDuration the default time after which a lock which has not been released expires
+	DefaultDuration = 5 * time.Minute
+
+	// DefaultTimeout the default time to wait for a lock held by another run of the same build
+	DefaultTimeout = 30 * time.Minute
+
+	defaultPollPeriod = 5 * time.Second
+	leaseNamePrefix   = "jx-test-lock-"
+)
+
+var (
+	info = termcolor.ColorInfo
+
+	// ErrSuperseded the lock is held by or was taken by a newer build
+	ErrSuperseded = errors.New("superseded by a newer build")
+)
+
+// Lock a Lease based lock which guards the test resources matching a selector. The Lease is renewed while the lock
+// is held. A newer build pre-empts the lock of an older build by asking it to stop and then waits for it to release the
+// lock or for the lock to expire rather than waiting for the older build to complete
+type Lock struct {
+	KubeClient kubernetes.Interface
+	Namespace  string
+	Labels     map[string]string
+
+	// Identity the unique identity of this run. Defaults to the host name of the pod
+	Identity string
+
+	// Build the build number of this run
+	Build string
+
+	// Duration how long the lock is held before it expires if it is not released
+	Duration time.Duration
+
+	// RenewPeriod how often the Lease is renewed while the lock is held. Defaults to a tenth of the Duration
+	RenewPeriod time.Duration
+
+	// Timeout how long to wait for a lock held by another run which cannot be pre-empted
+	Timeout    time.Duration
+	PollPeriod time.Duration
+
+	lease *coordinationv1.Lease
+
+	lock        sync.Mutex
+	lost        chan struct{}
+	lostErr     error
+	stopRenewal context.CancelFunc
+	renewalDone chan struct{}
+}
+
+// LeaseName returns the name of the Lease guarding the resources matching the labels
+func LeaseName(labels map[string]string) string {
+	sum := sha256.Sum256([]byte(dynkube.ToSelector(labels)))
+	return leaseNamePrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+// Name returns the name of the Lease
+func (l *Lock) Name() string {
+	return LeaseName(l.Labels)
+}
+
+// Acquire acquires the lock, pre-empting any lock held by an older build or which has expired. If the lock is
+// held by a newer build ErrSuperseded is returned. Otherwise it waits until the lock is released or the timeout expires
+func (l *Lock) Acquire(ctx context.Context) error {
+	if l.KubeClient == nil {
+		return fmt.Errorf("no KubeClient")
+	}
+	if l.Identity == "" {
+		hostName, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to find the host name: %w", err)
+		}
+		l.Identity = hostName
+	}
+	if l.Duration <= 0 {
+		l.Duration = DefaultDuration
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultTimeout
+	}
+	if l.PollPeriod <= 0 {
+		l.PollPeriod = defaultPollPeriod
+	}
+	if l.RenewPeriod <= 0 {
+		l.RenewPeriod = l.Duration / 10
+	}
+
+	name := l.Name()
+	waitCtx, cancel := context.WithTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	for {
+		acquired, err := l.tryAcquire(waitCtx)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			log.Logger().Infof("acquired the lock Lease %s in namespace %s", info(name), info(l.Namespace))
+			l.startRenewal(ctx)
+			return nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("timed out after %s waiting for the lock Lease %s in namespace %s held by %s", l.Timeout.String(), name, l.Namespace, HolderDescription(l.lease))
+		case <-time.After(l.PollPeriod):
+		}
+	}
+}
+
+// tryAcquire attempts to take the lock returning false if it is held by another run of the same or an unknown build
+func (l *Lock) tryAcquire(ctx context.Context) (bool, error) {
+	name := l.Name()
+	leaseInterface := l.KubeClient.CoordinationV1().Leases(l.Namespace)
+	lease, err := leaseInterface.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease, err = leaseInterface.Create(ctx, l.toLease(&coordinationv1.Lease{}), metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to create Lease %s in namespace %s: %w", name, l.Namespace, err)
+		}
+		l.lease = lease
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	l.lease = lease
+
+	switch {
+	case l.isHolder(lease):
+	case IsExpired(lease, time.Now()):
+		log.Logger().Infof("taking over the expired lock Lease %s held by %s", info(name), HolderDescription(lease))
+	default:
+		order := compareBuilds(l.Build, lease.Annotations[AnnotationBuild])
+		if order < 0 {
+			return false, fmt.Errorf("the lock Lease %s is held by %s: %w", name, HolderDescription(lease), ErrSuperseded)
+		}
+		if order == 0 {
+			log.Logger().Infof("waiting for the lock Lease %s held by %s", info(name), HolderDescription(lease))
+			return false, nil
+		}
+		return false, l.preempt(ctx, lease)
+	}
+
+	lease, err = leaseInterface.Update(ctx, l.toLease(lease), metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	l.lease = lease
+	return true, nil
+}
+
+// preempt asks the older build holding the Lease to stop so that it releases the lock. The holder may still be
+// removing or creating resources so the lock is only taken once it is released or expires
+func (l *Lock) preempt(ctx context.Context, lease *coordinationv1.Lease) error {
+	name := l.Name()
+	preemptedBy := lease.Annotations[AnnotationPreemptedBy]
+	if compareBuilds(l.Build, preemptedBy) < 0 {
+		return fmt.Errorf("the lock Lease %s held by %s is being pre-empted by build %s: %w", name, HolderDescription(lease), preemptedBy, ErrSuperseded)
+	}
+	if preemptedBy != l.Build {
+		lease.Annotations[AnnotationPreemptedBy] = l.Build
+		updated, err := l.KubeClient.CoordinationV1().Leases(l.Namespace).Update(ctx, lease, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update Lease %s in namespace %s: %w", name, l.Namespace, err)
+		}
+		l.lease = updated
+	}
+	log.Logger().Infof("waiting for older %s to stop and release the lock Lease %s", HolderDescription(lease), info(name))
+	return nil
+}
+
+// Check returns an error wrapping ErrSuperseded if the lock has been taken or pre-empted by another build
+func (l *Lock) Check(ctx context.Context) error {
+	err := l.lostError()
+	if err != nil {
+		return err
+	}
+	name := l.Name()
+	lease, err := l.KubeClient.CoordinationV1().Leases(l.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("the lock Lease %s in namespace %s was removed", name, l.Namespace)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	return l.checkHolder(lease)
+}
+
+// checkHolder returns an error wrapping ErrSuperseded if the Lease is no longer held by this run or a newer build
+// is waiting for this run to stop
+func (l *Lock) checkHolder(lease *coordinationv1.Lease) error {
+	name := l.Name()
+	if !l.isHolder(lease) {
+		return fmt.Errorf("the lock Lease %s was taken by %s: %w", name, HolderDescription(lease), ErrSuperseded)
+	}
+	preemptedBy := lease.Annotations[AnnotationPreemptedBy]
+	if preemptedBy != "" {
+		return fmt.Errorf("the lock Lease %s was pre-empted by build %s: %w", name, preemptedBy, ErrSuperseded)
+	}
+	return nil
+}
+
+// Done returns a channel which is closed if the lock is lost while it is held, for example because a newer build
+// pre-empted it. Check returns the reason the lock was lost
+func (l *Lock) Done() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lost == nil {
+		l.lost = make(chan struct{})
+	}
+	return l.lost
+}
+
+// startRenewal renews the Lease in the background until the lock is released or lost
+func (l *Lock) startRenewal(ctx context.Context) {
+	l.Done()
+	renewCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	l.stopRenewal = cancel
+	l.renewalDone = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.RenewPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := l.renew(renewCtx)
+			if errors.Is(err, ErrSuperseded) {
+				log.Logger().Warnf("stopping as %s", err.Error())
+				l.markLost(err)
+				return
+			}
+			if err != nil && renewCtx.Err() == nil {
+				log.Logger().Warnf("failed to renew the lock Lease %s: %s", l.Name(), err.Error())
+			}
+		}
+	}()
+}
+
+// renew renews the Lease returning an error wrapping ErrSuperseded if the lock has been lost
+func (l *Lock) renew(ctx context.Context) error {
+	name := l.Name()
+	leaseInterface := l.KubeClient.CoordinationV1().Leases(l.Namespace)
+	lease, err := leaseInterface.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("the lock Lease %s in namespace %s was removed: %w", name, l.Namespace, ErrSuperseded)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	err = l.checkHolder(lease)
+	if err != nil {
+		return err
+	}
+	lease, err = leaseInterface.Update(ctx, l.toLease(lease), metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		// lets check again on the next renewal
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	l.lock.Lock()
+	l.lease = lease
+	l.lock.Unlock()
+	return nil
+}
+
+// stopRenewing stops renewing the Lease and waits for any renewal in progress to complete
+func (l *Lock) stopRenewing() {
+	if l.stopRenewal == nil {
+		return
+	}
+	l.stopRenewal()
+	<-l.renewalDone
+	l.stopRenewal = nil
+}
+
+func (l *Lock) markLost(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lostErr == nil {
+		l.lostErr = err
+		close(l.lost)
+	}
+}
+
+func (l *Lock) lostError() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lostErr
+}
+
+// Release stops renewing the lock and releases it by deleting its Lease if it is still held by this run. This lets
+// a newer build which pre-empted the lock take it
+func (l *Lock) Release(ctx context.Context) error {
+	l.stopRenewing()
+	if l.lease == nil || !l.isHolder(l.lease) {
+		return nil
+	}
+	name := l.Name()
+	leaseInterface := l.KubeClient.CoordinationV1().Leases(l.Namespace)
+	lease, err := leaseInterface.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		l.lease = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	if !l.isHolder(lease) {
+		log.Logger().Debugf("not releasing the lock Lease %s as it was taken by %s", name, HolderDescription(lease))
+		l.lease = nil
+		return nil
+	}
+
+	// only delete the Lease if it has not been taken by another build since we got it
+	resourceVersion := lease.ResourceVersion
+	err = leaseInterface.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion},
+	})
+	if apierrors.IsConflict(err) {
+		log.Logger().Debugf("not releasing the lock Lease %s as it was modified by another build", name)
+		l.lease = nil
+		return nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Lease %s in namespace %s: %w", name, l.Namespace, err)
+	}
+	l.lease = nil
+	log.Logger().Infof("released the lock Lease %s", info(name))
+	return nil
+}
+
+// IsLockLease returns true if the Lease is the lock of a test
+func IsLockLease(lease *coordinationv1.Lease) bool {
+	return strings.HasPrefix(lease.Name, leaseNamePrefix) && lease.Annotations[AnnotationSelector] != ""
+}
+
+func (l *Lock) isHolder(lease *coordinationv1.Lease) bool {
+	return lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == l.Identity
+}
+
+// toLease populates the Lease with this run as the holder
+func (l *Lock) toLease(lease *coordinationv1.Lease) *coordinationv1.Lease {
+	lease.Name = l.Name()
+	lease.Namespace = l.Namespace
+	if lease.Labels == nil {
+		lease.Labels = map[string]string{}
+	}
+	for k, v := range l.Labels {
+		lease.Labels[k] = v
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[AnnotationBuild] = l.Build
+	lease.Annotations[AnnotationSelector] = dynkube.ToSelector(l.Labels)
+	delete(lease.Annotations, AnnotationPreemptedBy)
+
+	now := metav1.NewMicroTime(time.Now())
+	identity := l.Identity
+	seconds := int32(l.Duration.Seconds())
+	if !l.isHolder(lease) {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	return lease
+}
+
+// IsExpired returns true if the holder of the Lease has not renewed it within its duration
+func IsExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expires)
+}
+
+// compareBuilds compares the build numbers returning a negative number if a is older than b, a positive number if
+// it is newer or 0 if they are the same or cannot be compared
+func compareBuilds(a, b string) int {
+	ai, err := strconv.Atoi(a)
+	if err != nil {
+		return 0
+	}
+	bi, err := strconv.Atoi(b)
+	if err != nil {
+		return 0
+	}
+	return ai - bi
+}
+
+// HolderDescription returns a description of the build holding the Lease
+func HolderDescription(lease *coordinationv1.Lease) string {
+	if lease == nil {
+		return "another build"
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	return fmt.Sprintf("build %s (%s)", lease.Annotations[AnnotationBuild], holder)
+}
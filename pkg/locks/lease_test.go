@@ -0,0 +1,133 @@
+package locks_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/locks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const ns = "jx"
+
+var labels = map[string]string{
+	"kind":    "jx-test",
+	"repo":    "myrepo",
+	"pr":      "pr-456",
+	"context": "myctx",
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.TODO()
+	kubeClient := fake.NewSimpleClientset()
+
+	older := newLock(kubeClient, "older", "3")
+	err := older.Acquire(ctx)
+	require.NoError(t, err, "failed to acquire lock for build 3")
+	require.NoError(t, older.Check(ctx), "build 3 should hold the lock")
+
+	lease, err := kubeClient.CoordinationV1().Leases(ns).Get(ctx, locks.LeaseName(labels), metav1.GetOptions{})
+	require.NoError(t, err, "failed to get Lease")
+	assert.Equal(t, "3", lease.Annotations[locks.AnnotationBuild], "build annotation")
+	assert.Equal(t, "myrepo", lease.Labels["repo"], "repo label")
+
+	// another run of the same build has to wait
+	same := newLock(kubeClient, "same", "3")
+	same.Timeout = 20 * time.Millisecond
+	err = same.Acquire(ctx)
+	require.Error(t, err, "should have timed out waiting for the lock held by the same build")
+	assert.NotErrorIs(t, err, locks.ErrSuperseded, "should not be superseded by the same build")
+
+	// a newer build pre-empts the lock of the older build once the older build has stopped and released it
+	newer := newLock(kubeClient, "newer", "4")
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- newer.Acquire(ctx)
+	}()
+	select {
+	case <-older.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "build 3 should have been told to stop")
+	}
+	err = older.Check(ctx)
+	require.ErrorIs(t, err, locks.ErrSuperseded, "build 3 should have lost the lock")
+	assert.Error(t, newer.Check(ctx), "build 4 should not hold the lock until build 3 releases it")
+
+	require.NoError(t, older.Release(ctx), "failed to release lock of build 3")
+	require.NoError(t, <-acquired, "failed to pre-empt lock for build 4")
+	require.NoError(t, newer.Check(ctx), "build 4 should hold the lock")
+	require.NoError(t, older.Release(ctx), "failed to release lock of build 3 again")
+	require.NoError(t, newer.Check(ctx), "releasing a lost lock should not remove the lock of build 4")
+
+	// an older build does not wait for a newer build
+	err = newLock(kubeClient, "oldest", "2").Acquire(ctx)
+	require.ErrorIs(t, err, locks.ErrSuperseded, "build 2 should be superseded by build 4")
+
+	require.NoError(t, newer.Release(ctx), "failed to release lock of build 4")
+	_, err = kubeClient.CoordinationV1().Leases(ns).Get(ctx, locks.LeaseName(labels), metav1.GetOptions{})
+	require.Error(t, err, "should have removed the Lease")
+}
+
+func TestLockExpired(t *testing.T) {
+	ctx := context.TODO()
+	identity := "crashed"
+	seconds := int32(60)
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+	kubeClient := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        locks.LeaseName(labels),
+			Namespace:   ns,
+			Annotations: map[string]string{locks.AnnotationBuild: "3"},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &identity,
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &renewed,
+		},
+	})
+
+	l := newLock(kubeClient, "retry", "3")
+	err := l.Acquire(ctx)
+	require.NoError(t, err, "should have taken over the expired lock")
+	require.NoError(t, l.Check(ctx), "should hold the lock")
+}
+
+func TestLockRenew(t *testing.T) {
+	ctx := context.TODO()
+	kubeClient := fake.NewSimpleClientset()
+
+	l := newLock(kubeClient, "renew", "3")
+	l.Duration = time.Second
+	l.RenewPeriod = 10 * time.Millisecond
+	err := l.Acquire(ctx)
+	require.NoError(t, err, "failed to acquire lock")
+
+	lease, err := kubeClient.CoordinationV1().Leases(ns).Get(ctx, locks.LeaseName(labels), metav1.GetOptions{})
+	require.NoError(t, err, "failed to get Lease")
+	acquired := lease.Spec.RenewTime.Time
+
+	assert.Eventually(t, func() bool {
+		lease, err = kubeClient.CoordinationV1().Leases(ns).Get(ctx, locks.LeaseName(labels), metav1.GetOptions{})
+		return err == nil && lease.Spec.RenewTime.After(acquired)
+	}, time.Second, 5*time.Millisecond, "should have renewed the Lease")
+	require.NoError(t, l.Release(ctx), "failed to release lock")
+}
+
+func newLock(kubeClient *fake.Clientset, identity, build string) *locks.Lock {
+	return &locks.Lock{
+		KubeClient:  kubeClient,
+		Namespace:   ns,
+		Labels:      labels,
+		Identity:    identity,
+		Build:       build,
+		Timeout:     time.Second,
+		PollPeriod:  time.Millisecond,
+		RenewPeriod: time.Millisecond,
+	}
+}
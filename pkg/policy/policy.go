@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// LabelKeep the label used to keep a test resource so that it is not removed
+	LabelKeep = "keep"
+
+	// AnnotationTTL the annotation for the duration after the creation of a test resource when it expires
+	// such as 6h. Before it expires the resource is kept
+	AnnotationTTL = "jx-test.jenkins-x.io/ttl"
+
+	// AnnotationExpiresAt the annotation for the RFC 3339 time when a test resource expires.
+	// Before it expires the resource is kept
+	AnnotationExpiresAt = "jx-test.jenkins-x.io/expires-at"
+)
+
+// Decision the result of evaluating the keep policy of a resource
+type Decision struct {
+	// Keep whether the resource must be kept
+	Keep bool
+
+	// Reason a human readable reason for the decision
+	Reason string
+
+	// ExpiresAt when the resource expires if it has a TTL or expiry annotation
+	ExpiresAt *time.Time
+}
+
+// IsKeepValue returns true if the value of the keep label means the resource should be kept
+func IsKeepValue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "y", "1", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// Evaluate evaluates the keep policy of the resource at the given time.
+//
+// A resource with an expires-at or ttl annotation is kept until it expires whether or not it has a keep label.
+// A resource whose annotation cannot be parsed is kept and a warning is logged.
+// Otherwise a resource is kept if it has a keep label with a value accepted by IsKeepValue
+func Evaluate(obj metav1.Object, now time.Time) Decision {
+	expiresAt, err := ExpiresAt(obj)
+	if err != nil {
+		// lets not remove a resource someone meant to keep but make sure the mistake is noticed
+		reason := fmt.Sprintf("%s so it is kept until the annotation is fixed", err.Error())
+		log.Logger().Warnf("%s has an %s", obj.GetName(), reason)
+		return Decision{
+			Keep:   true,
+			Reason: reason,
+		}
+	}
+	if expiresAt != nil {
+		if now.Before(*expiresAt) {
+			return Decision{
+				Keep:      true,
+				Reason:    "kept until " + expiresAt.Format(time.RFC3339),
+				ExpiresAt: expiresAt,
+			}
+		}
+		return Decision{
+			Reason:    "expired at " + expiresAt.Format(time.RFC3339),
+			ExpiresAt: expiresAt,
+		}
+	}
+
+	keep := obj.GetLabels()[LabelKeep]
+	if IsKeepValue(keep) {
+		return Decision{
+			Keep:   true,
+			Reason: "has keep label",
+		}
+	}
+	return Decision{}
+}
+
+// ExpiresAt returns the expiry time of the resource from its expires-at or ttl annotations
+// or nil if it has no expiry
+func ExpiresAt(obj metav1.Object) (*time.Time, error) {
+	annotations := obj.GetAnnotations()
+	value := annotations[AnnotationExpiresAt]
+	if value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %s", AnnotationExpiresAt, value)
+		}
+		return &t, nil
+	}
+
+	value = annotations[AnnotationTTL]
+	if value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %s", AnnotationTTL, value)
+		}
+		t := obj.GetCreationTimestamp().Add(ttl)
+		return &t, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+package policy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/policy"
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	created := metav1.Time{Time: now.Add(-3 * time.Hour)}
+
+	testCases := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		keep        bool
+	}{
+		{
+			name: "no-labels",
+		},
+		{
+			name:   "keep-yes",
+			labels: map[string]string{policy.LabelKeep: "yes"},
+			keep:   true,
+		},
+		{
+			name:   "keep-true",
+			labels: map[string]string{policy.LabelKeep: "True"},
+			keep:   true,
+		},
+		{
+			name:   "keep-on",
+			labels: map[string]string{policy.LabelKeep: "on"},
+			keep:   true,
+		},
+		{
+			name:   "keep-false",
+			labels: map[string]string{policy.LabelKeep: "false"},
+		},
+		{
+			name:        "ttl-not-expired",
+			annotations: map[string]string{policy.AnnotationTTL: "6h"},
+			keep:        true,
+		},
+		{
+			name:        "ttl-expired",
+			labels:      map[string]string{policy.LabelKeep: "yes"},
+			annotations: map[string]string{policy.AnnotationTTL: "2h"},
+		},
+		{
+			name:        "expires-at-not-expired",
+			annotations: map[string]string{policy.AnnotationExpiresAt: now.Add(time.Hour).Format(time.RFC3339)},
+			keep:        true,
+		},
+		{
+			name:        "expires-at-expired",
+			annotations: map[string]string{policy.AnnotationExpiresAt: now.Add(-time.Hour).Format(time.RFC3339)},
+		},
+		{
+			name:        "invalid-ttl",
+			annotations: map[string]string{policy.AnnotationTTL: "a while"},
+			keep:        true,
+		},
+		{
+			name:        "invalid-expires-at",
+			annotations: map[string]string{policy.AnnotationExpiresAt: "tomorrow"},
+			keep:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		obj := &metav1.ObjectMeta{
+			Name:              tc.name,
+			Labels:            tc.labels,
+			Annotations:       tc.annotations,
+			CreationTimestamp: created,
+		}
+		decision := policy.Evaluate(obj, now)
+		assert.Equal(t, tc.keep, decision.Keep, "keep for %s: %s", tc.name, decision.Reason)
+	}
+}
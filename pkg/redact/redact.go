@@ -0,0 +1,135 @@
+package redact
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// Mask the text which replaces sensitive values
+	Mask = "*****"
+
+	// minValueLength the minimum length of a sensitive value which is masked wherever it appears in text so that
+	// common short values such as true or 1 are not masked everywhere
+	minValueLength = 4
+)
+
+// DefaultPatterns the default patterns of the names of sensitive environment variables
+var DefaultPatterns = []string{"*_TOKEN", "*_PASSWORD", "*_KEY"}
+
+// Redactor masks sensitive values in text and objects
+type Redactor struct {
+	patterns []string
+	values   []string
+}
+
+// New creates a redactor for environment variables whose names match the given case insensitive glob patterns
+func New(patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range patterns {
+		p = strings.ToUpper(p)
+		_, err := path.Match(p, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+		r.patterns = append(r.patterns, p)
+	}
+	return r, nil
+}
+
+// IsSensitive returns true if the name of the environment variable matches one of the patterns
+func (r *Redactor) IsSensitive(name string) bool {
+	name = strings.ToUpper(name)
+	for _, p := range r.patterns {
+		if matched, _ := path.Match(p, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AddValues adds values which are masked wherever they appear
+func (r *Redactor) AddValues(values ...string) {
+	for _, v := range values {
+		if len(v) < minValueLength {
+			continue
+		}
+		r.values = append(r.values, v)
+	}
+	// lets replace the longest values first in case they contain other values
+	sort.SliceStable(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+}
+
+// AddEnv adds the values of the sensitive environment variables
+func (r *Redactor) AddEnv(env map[string]string) {
+	for k, v := range env {
+		if r.IsSensitive(k) {
+			r.AddValues(v)
+		}
+	}
+}
+
+// Text masks the sensitive values in the text
+func (r *Redactor) Text(text string) string {
+	if r == nil {
+		return text
+	}
+	for _, v := range r.values {
+		text = strings.ReplaceAll(text, v, Mask)
+	}
+	return text
+}
+
+// Object returns a copy of the object with the data of Secrets, the values of sensitive environment variables
+// and any other sensitive values masked
+func (r *Redactor) Object(u *unstructured.Unstructured) *unstructured.Unstructured {
+	answer := u.DeepCopy()
+	if r == nil {
+		return answer
+	}
+	if answer.GetAPIVersion() == "v1" && answer.GetKind() == "Secret" {
+		for _, field := range []string{"data", "stringData"} {
+			m, ok := answer.Object[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for k := range m {
+				m[k] = Mask
+			}
+		}
+	}
+	answer.Object = r.value(answer.Object).(map[string]interface{})
+	return answer
+}
+
+// value masks the sensitive values in the value recursively
+func (r *Redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// lets mask env var entries of the form name: FOO_TOKEN, value: something
+		if name, ok := v["name"].(string); ok && r.IsSensitive(name) {
+			if _, ok := v["value"].(string); ok {
+				v["value"] = Mask
+			}
+		}
+		for k, child := range v {
+			v[k] = r.value(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.value(child)
+		}
+		return v
+	case string:
+		return r.Text(v)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,127 @@
+package redact_test
+
+import (
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/redact"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func TestIsSensitive(t *testing.T) {
+	r, err := redact.New(redact.DefaultPatterns)
+	require.NoError(t, err, "failed to create redactor")
+
+	testCases := map[string]bool{
+		"TF_VAR_jx_bot_token": true,
+		"GIT_PASSWORD":        true,
+		"TF_VAR_API_KEY":      true,
+		"TF_VAR_cluster_name": false,
+		"TOKEN":               false,
+		"TF_VAR_keyring":      false,
+	}
+	for name, expected := range testCases {
+		assert.Equal(t, expected, r.IsSensitive(name), "IsSensitive(%s)", name)
+	}
+
+	_, err = redact.New([]string{"[TOKEN"})
+	require.Error(t, err, "should fail to parse an invalid pattern")
+}
+
+func TestRedact(t *testing.T) {
+	r, err := redact.New(redact.DefaultPatterns)
+	require.NoError(t, err, "failed to create redactor")
+	r.AddEnv(map[string]string{
+		"TF_VAR_jx_bot_token": "my-bot-token",
+		"TF_VAR_cluster_name": "mycluster",
+		"TF_VAR_short_key":    "abc",
+	})
+	r.AddValues("another-secret")
+
+	assert.Equal(t, "git clone https://*****@github.com/*****/mycluster", r.Text("git clone https://my-bot-token@github.com/another-secret/mycluster"), "Text")
+
+	testCases := []struct {
+		name     string
+		yaml     string
+		expected string
+	}{
+		{
+			name: "secret",
+			yaml: `apiVersion: v1
+kind: Secret
+metadata:
+  name: mysecret
+data:
+  password: c2VjcmV0
+stringData:
+  username: admin
+`,
+			expected: `apiVersion: v1
+data:
+  password: '*****'
+kind: Secret
+metadata:
+  name: mysecret
+stringData:
+  username: '*****'
+`,
+		},
+		{
+			name: "env",
+			yaml: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-redact
+spec:
+  env:
+  - name: TF_VAR_db_password
+    value: hunter2
+  - name: TF_VAR_cluster_name
+    value: mycluster
+  - name: TF_VAR_git_url
+    value: https://my-bot-token@github.com/myorg/myrepo.git
+  - name: TF_VAR_api_key
+    valueFrom:
+      secretKeyRef:
+        name: api
+        key: key
+`,
+			expected: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-redact
+spec:
+  env:
+  - name: TF_VAR_db_password
+    value: '*****'
+  - name: TF_VAR_cluster_name
+    value: mycluster
+  - name: TF_VAR_git_url
+    value: https://*****@github.com/myorg/myrepo.git
+  - name: TF_VAR_api_key
+    valueFrom:
+      secretKeyRef:
+        key: key
+        name: api
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		u := &unstructured.Unstructured{}
+		err := yaml.Unmarshal([]byte(tc.yaml), &u.Object)
+		require.NoError(t, err, "failed to parse YAML for %s", tc.name)
+
+		redacted := r.Object(u)
+		data, err := yaml.Marshal(redacted.Object)
+		require.NoError(t, err, "failed to marshal YAML for %s", tc.name)
+		assert.Equal(t, tc.expected, string(data), "redacted YAML for %s", tc.name)
+
+		original, err := yaml.Marshal(u.Object)
+		require.NoError(t, err, "failed to marshal YAML for %s", tc.name)
+		assert.NotContains(t, string(original), redact.Mask, "should not modify the original object for %s", tc.name)
+	}
+}
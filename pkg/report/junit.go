@@ -0,0 +1,102 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// junitSuites the root element of a JUnit XML report
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML to the given file with a test case for each phase
+func (r *Report) WriteJUnit(path string) error {
+	suite := junitSuite{
+		Name:      r.Name,
+		Time:      formatSeconds(r.DurationSeconds),
+		Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+	}
+	if r.Namespace != "" {
+		suite.Properties = append(suite.Properties, junitProperty{Name: "namespace", Value: r.Namespace})
+	}
+	var keys []string
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		suite.Properties = append(suite.Properties, junitProperty{Name: "label." + k, Value: r.Labels[k]})
+	}
+
+	failed := false
+	for _, p := range r.Phases {
+		c := junitCase{
+			Name:      p.Name,
+			ClassName: r.Name,
+			Time:      formatSeconds(p.DurationSeconds),
+		}
+		switch p.Outcome {
+		case OutcomeFailed:
+			c.Failure = &junitMessage{Message: p.Message, Text: p.Message}
+			suite.Failures++
+			failed = true
+		case OutcomeSkipped:
+			c.Skipped = &junitMessage{Message: p.Message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	// make sure a failure outside of any phase is still reported
+	if r.Outcome == OutcomeFailed && !failed {
+		suite.Cases = append(suite.Cases, junitCase{
+			Name:      "run",
+			ClassName: r.Name,
+			Time:      formatSeconds(r.DurationSeconds),
+			Failure:   &junitMessage{Message: r.Failure, Text: r.Failure},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report to JUnit XML: %w", err)
+	}
+	return writeFile(path, append([]byte(xml.Header), append(data, '\n')...))
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/files"
+)
+
+// Outcome the outcome of a test run or one of its phases
+type Outcome string
+
+const (
+	// OutcomePassed the test run or phase passed
+	OutcomePassed Outcome = "passed"
+
+	// OutcomeFailed the test run or phase failed
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeSkipped the phase was skipped
+	OutcomeSkipped Outcome = "skipped"
+
+	// PhaseRender renders the templates of the test resources
+	PhaseRender = "render"
+
+	// PhaseCleanup removes the resources of previous runs
+	PhaseCleanup = "cleanup"
+
+	// PhaseCreate creates the test resources
+	PhaseCreate = "create"
+
+	// PhaseJob waits for the Job of the test resource to complete
+	PhaseJob = "job"
+
+	// PhaseDelete deletes the test resources
+	PhaseDelete = "delete"
+)
+
+// Report the result of a test run
+type Report struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Started         time.Time         `json:"started"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Outcome         Outcome           `json:"outcome"`
+	Failure         string            `json:"failure,omitempty"`
+	JobOutcome      string            `json:"jobOutcome,omitempty"`
+	Phases          []*Phase          `json:"phases"`
+}
+
+// Phase the result of a phase of a test run
+type Phase struct {
+	Name            string    `json:"name"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Outcome         Outcome   `json:"outcome"`
+	Message         string    `json:"message,omitempty"`
+}
+
+// New creates a new report for a test run started at the given time
+func New(started time.Time) *Report {
+	return &Report{
+		Started: started,
+	}
+}
+
+// StartPhase starts timing a new phase of the test run. Call End on the phase once it completes
+func (r *Report) StartPhase(name string) *Phase {
+	p := &Phase{
+		Name:    name,
+		Started: time.Now(),
+	}
+	r.Phases = append(r.Phases, p)
+	return p
+}
+
+// Skip records that the phase was skipped for the given reason
+func (r *Report) Skip(name, reason string) {
+	r.Phases = append(r.Phases, &Phase{
+		Name:    name,
+		Started: time.Now(),
+		Outcome: OutcomeSkipped,
+		Message: reason,
+	})
+}
+
+// End records the duration of the phase and whether it failed with the given error
+func (p *Phase) End(err error) {
+	p.DurationSeconds = time.Since(p.Started).Seconds()
+	if err != nil {
+		p.Outcome = OutcomeFailed
+		p.Message = err.Error()
+		return
+	}
+	p.Outcome = OutcomePassed
+}
+
+// Finish records the overall duration and outcome of the test run
+func (r *Report) Finish(err error, finished time.Time) {
+	r.DurationSeconds = finished.Sub(r.Started).Seconds()
+	if err != nil {
+		r.Outcome = OutcomeFailed
+		r.Failure = err.Error()
+		return
+	}
+	r.Outcome = OutcomePassed
+}
+
+// Redact masks any sensitive values in the failure messages of the report using the given function
+func (r *Report) Redact(fn func(string) string) {
+	r.Failure = fn(r.Failure)
+	for _, p := range r.Phases {
+		p.Message = fn(p.Message)
+	}
+}
+
+// WriteJSON writes the report as JSON to the given file
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report to JSON: %w", err)
+	}
+	return writeFile(path, data)
+}
+
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, files.DefaultDirWritePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	err = os.WriteFile(path, data, files.DefaultFileWritePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to save file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package report_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/report"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type junitSuites struct {
+	Suites []struct {
+		Name       string `xml:"name,attr"`
+		Tests      int    `xml:"tests,attr"`
+		Failures   int    `xml:"failures,attr"`
+		Skipped    int    `xml:"skipped,attr"`
+		Properties []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"properties>property"`
+		Cases []struct {
+			Name    string `xml:"name,attr"`
+			Failure *struct {
+				Message string `xml:"message,attr"`
+			} `xml:"failure"`
+		} `xml:"testcase"`
+	} `xml:"testsuite"`
+}
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	r := report.New(time.Now())
+	r.Name = "tf-myrepo-pr456-myctx-1"
+	r.Namespace = "jx"
+	r.Labels = map[string]string{"repo": "myrepo", "pr": "pr-456"}
+
+	r.StartPhase(report.PhaseRender).End(nil)
+	r.StartPhase(report.PhaseCleanup).End(nil)
+	r.StartPhase(report.PhaseCreate).End(nil)
+	jobErr := fmt.Errorf("the job failed")
+	r.StartPhase(report.PhaseJob).End(jobErr)
+	r.Skip(report.PhaseDelete, "the job failed")
+	r.Finish(jobErr, time.Now())
+
+	jsonPath := filepath.Join(dir, "reports", "result.json")
+	err := r.WriteJSON(jsonPath)
+	require.NoError(t, err, "failed to write JSON report")
+
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err, "failed to read %s", jsonPath)
+	got := &report.Report{}
+	err = json.Unmarshal(data, got)
+	require.NoError(t, err, "failed to parse %s", jsonPath)
+	assert.Equal(t, report.OutcomeFailed, got.Outcome, "outcome")
+	assert.Equal(t, "the job failed", got.Failure, "failure")
+	require.Len(t, got.Phases, 5, "phases")
+	assert.Equal(t, report.OutcomeFailed, got.Phases[3].Outcome, "job phase outcome")
+	assert.Equal(t, report.OutcomeSkipped, got.Phases[4].Outcome, "delete phase outcome")
+
+	junitPath := filepath.Join(dir, "reports", "junit.xml")
+	err = r.WriteJUnit(junitPath)
+	require.NoError(t, err, "failed to write JUnit report")
+
+	data, err = os.ReadFile(junitPath)
+	require.NoError(t, err, "failed to read %s", junitPath)
+	suites := &junitSuites{}
+	err = xml.Unmarshal(data, suites)
+	require.NoError(t, err, "failed to parse %s", junitPath)
+	require.Len(t, suites.Suites, 1, "test suites")
+
+	suite := suites.Suites[0]
+	assert.Equal(t, "tf-myrepo-pr456-myctx-1", suite.Name, "suite name")
+	assert.Equal(t, 5, suite.Tests, "tests")
+	assert.Equal(t, 1, suite.Failures, "failures")
+	assert.Equal(t, 1, suite.Skipped, "skipped")
+	require.NotNil(t, suite.Cases[3].Failure, "job failure")
+	assert.Equal(t, "the job failed", suite.Cases[3].Failure.Message, "job failure message")
+	assert.Len(t, suite.Properties, 3, "properties")
+}
+
+func TestReportFailureOutsidePhase(t *testing.T) {
+	r := report.New(time.Now())
+	r.Name = "tf-abc"
+	r.Finish(fmt.Errorf("failed to validate"), time.Now())
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	err := r.WriteJUnit(path)
+	require.NoError(t, err, "failed to write JUnit report")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err, "failed to read %s", path)
+	suites := &junitSuites{}
+	err = xml.Unmarshal(data, suites)
+	require.NoError(t, err, "failed to parse %s", path)
+
+	suite := suites.Suites[0]
+	assert.Equal(t, 1, suite.Failures, "failures")
+	require.Len(t, suite.Cases, 1, "test cases")
+	assert.Equal(t, "run", suite.Cases[0].Name, "test case name")
+}
+
+func TestReportRedact(t *testing.T) {
+	started := time.Now()
+	r := report.New(started)
+	phase := r.StartPhase(report.PhaseRender)
+	err := fmt.Errorf("failed to parse token: my-bot-token")
+	phase.End(err)
+	r.Finish(err, started.Add(time.Second))
+
+	r.Redact(func(text string) string {
+		return strings.ReplaceAll(text, "my-bot-token", "*****")
+	})
+	assert.Equal(t, "failed to parse token: *****", r.Failure, "r.Failure")
+	assert.Equal(t, "failed to parse token: *****", r.Phases[0].Message, "r.Phases[0].Message")
+}
@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// CRDResource the resource of CustomResourceDefinitions
+var CRDResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// LoadCRD loads the schemas of the CustomResourceDefinition of the given resource from the cluster.
+// Built in resources and resources whose CustomResourceDefinition cannot be found or read are ignored
+// returning false
+func (v *Validator) LoadCRD(ctx context.Context, client dynamic.Interface, gr schema.GroupResource) (bool, error) {
+	if gr.Group == "" || client == nil {
+		return false, nil
+	}
+	name := gr.String()
+	crd, err := client.Resource(CRDResource).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+			log.Logger().Debugf("cannot load %s %s: %s", CRDKind, name, err.Error())
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get %s %s: %w", CRDKind, name, err)
+	}
+	err = v.AddCRD(crd)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,246 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CRDKind the kind of a CustomResourceDefinition
+	CRDKind = "CustomResourceDefinition"
+
+	// ExtensionPreserveUnknownFields the schema extension which allows fields not specified in the schema
+	ExtensionPreserveUnknownFields = "x-kubernetes-preserve-unknown-fields"
+
+	// ExtensionEmbeddedResource the schema extension for fields containing a whole object
+	ExtensionEmbeddedResource = "x-kubernetes-embedded-resource"
+)
+
+// Validator validates objects against the OpenAPI v3 schemas of CustomResourceDefinitions
+type Validator struct {
+	schemas map[schema.GroupVersionKind]*spec.Schema
+}
+
+// NewValidator creates a new validator with no schemas
+func NewValidator() *Validator {
+	return &Validator{
+		schemas: map[schema.GroupVersionKind]*spec.Schema{},
+	}
+}
+
+// LoadFiles loads the CustomResourceDefinitions in the given YAML files into the validator
+func (v *Validator) LoadFiles(paths ...string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load file %s: %w", path, err)
+		}
+		err = v.LoadYAML(data)
+		if err != nil {
+			return fmt.Errorf("failed to load schemas from file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// LoadYAML loads the CustomResourceDefinitions in the given YAML which can contain multiple documents
+func (v *Validator) LoadYAML(data []byte) error {
+	reader := kyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read YAML document: %w", err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{}
+		err = yaml.Unmarshal(doc, &u.Object)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal YAML: %w", err)
+		}
+		if len(u.Object) == 0 {
+			continue
+		}
+		if u.GetKind() != CRDKind {
+			log.Logger().Debugf("ignoring %s %s as it is not a %s", u.GetKind(), u.GetName(), CRDKind)
+			continue
+		}
+		err = v.AddCRD(u)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AddCRD adds the schemas of each version of the given CustomResourceDefinition
+func (v *Validator) AddCRD(crd *unstructured.Unstructured) error {
+	name := crd.GetName()
+	group, _, err := unstructured.NestedString(crd.Object, "spec", "group")
+	if err != nil || group == "" {
+		return fmt.Errorf("missing spec.group in %s %s", CRDKind, name)
+	}
+	kind, _, err := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	if err != nil || kind == "" {
+		return fmt.Errorf("missing spec.names.kind in %s %s", CRDKind, name)
+	}
+
+	// the schema shared by all versions in apiextensions.k8s.io/v1beta1
+	shared, _, err := unstructured.NestedMap(crd.Object, "spec", "validation", "openAPIV3Schema")
+	if err != nil {
+		return fmt.Errorf("failed to find spec.validation.openAPIV3Schema in %s %s: %w", CRDKind, name, err)
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return fmt.Errorf("failed to find spec.versions in %s %s: %w", CRDKind, name, err)
+	}
+	if len(versions) == 0 {
+		version, _, _ := unstructured.NestedString(crd.Object, "spec", "version")
+		if version != "" {
+			versions = append(versions, map[string]interface{}{"name": version})
+		}
+	}
+
+	for _, item := range versions {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		version, _, _ := unstructured.NestedString(m, "name")
+		if version == "" {
+			continue
+		}
+		raw, found, err := unstructured.NestedMap(m, "schema", "openAPIV3Schema")
+		if err != nil {
+			return fmt.Errorf("failed to find the schema of version %s in %s %s: %w", version, CRDKind, name, err)
+		}
+		if !found {
+			raw = shared
+		}
+		if raw == nil {
+			continue
+		}
+		s, err := toSchema(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse the schema of version %s in %s %s: %w", version, CRDKind, name, err)
+		}
+		v.schemas[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = s
+	}
+	return nil
+}
+
+// HasSchema returns true if there is a schema for the given kind
+func (v *Validator) HasSchema(gvk schema.GroupVersionKind) bool {
+	return v.schemas[gvk] != nil
+}
+
+// Validate validates the object against the schema of its kind reporting all the invalid and unknown fields.
+// Objects of kinds without a schema are valid
+func (v *Validator) Validate(u *unstructured.Unstructured) error {
+	gvk := u.GroupVersionKind()
+	s := v.schemas[gvk]
+	if s == nil {
+		return nil
+	}
+	var messages []string
+	result := validate.NewSchemaValidator(s, nil, "", strfmt.Default).Validate(u.Object)
+	if result != nil {
+		for _, e := range result.Errors {
+			messages = append(messages, e.Error())
+		}
+	}
+
+	// the API server silently prunes unknown fields so lets report them as typos
+	for _, path := range unknownFields(s, u.Object, "", true) {
+		messages = append(messages, fmt.Sprintf("%s is an unknown field", path))
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	sort.Strings(messages)
+	return fmt.Errorf("%s %s is not valid: %s", u.GetKind(), u.GetName(), strings.Join(messages, ", "))
+}
+
+// unknownFields returns the paths of the fields in the value which are not specified in the schema
+func unknownFields(s *spec.Schema, value interface{}, path string, embedded bool) []string {
+	if s == nil || preservesUnknownFields(s) {
+		return nil
+	}
+	var answer []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if embedded && (k == "apiVersion" || k == "kind" || k == "metadata") {
+				continue
+			}
+			if p, ok := s.Properties[k]; ok {
+				answer = append(answer, unknownFields(&p, child, childPath, isEmbeddedResource(&p))...)
+				continue
+			}
+			if s.AdditionalProperties != nil {
+				if s.AdditionalProperties.Schema != nil {
+					answer = append(answer, unknownFields(s.AdditionalProperties.Schema, child, childPath, false)...)
+				}
+				continue
+			}
+			if len(s.Properties) > 0 || embedded {
+				answer = append(answer, childPath)
+			}
+		}
+	case []interface{}:
+		if s.Items == nil || s.Items.Schema == nil {
+			return nil
+		}
+		for i, child := range v {
+			answer = append(answer, unknownFields(s.Items.Schema, child, fmt.Sprintf("%s[%d]", path, i), isEmbeddedResource(s.Items.Schema))...)
+		}
+	}
+	return answer
+}
+
+func preservesUnknownFields(s *spec.Schema) bool {
+	b, ok := s.Extensions.GetBool(ExtensionPreserveUnknownFields)
+	return ok && b
+}
+
+func isEmbeddedResource(s *spec.Schema) bool {
+	b, ok := s.Extensions.GetBool(ExtensionEmbeddedResource)
+	return ok && b
+}
+
+func toSchema(raw map[string]interface{}) (*spec.Schema, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	s := &spec.Schema{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schema: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,116 @@
+package schema_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidator(t *testing.T) {
+	v := schema.NewValidator()
+	err := v.LoadFiles(filepath.Join("test_data", "terraform-crd.yaml"))
+	require.NoError(t, err, "failed to load schemas")
+
+	gvk := k8sschema.GroupVersionKind{Group: "tf.isaaguilar.com", Version: "v1alpha1", Kind: "Terraform"}
+	require.True(t, v.HasSchema(gvk), "should have a schema for %s", gvk.String())
+
+	testCases := []struct {
+		name        string
+		yaml        string
+		expectError string
+	}{
+		{
+			name: "valid",
+			yaml: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-valid
+spec:
+  terraformVersion: 0.13.4
+  terraformModule:
+    address: https://github.com/jenkins-x-bdd/infra
+  applyOnCreate: true
+`,
+		},
+		{
+			name: "wrong type",
+			yaml: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-wrong-type
+spec:
+  terraformModule:
+    address: https://github.com/jenkins-x-bdd/infra
+  applyOnCreate: "yes"
+`,
+			expectError: "spec.applyOnCreate",
+		},
+		{
+			name: "missing required",
+			yaml: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-missing
+spec:
+  env:
+  - value: foo
+`,
+			expectError: "spec.terraformModule",
+		},
+		{
+			name: "unknown field",
+			yaml: `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  name: tf-typo
+  labels:
+    anything: goes
+spec:
+  terraformVerison: 0.13.4
+  terraformModule:
+    address: https://github.com/jenkins-x-bdd/infra
+    adress: https://github.com/jenkins-x-bdd/infra
+  env:
+  - name: foo
+    vaule: bar
+  labels:
+    anything: goes
+  outputsToOmit:
+    anything: goes
+`,
+			expectError: "spec.env[0].vaule is an unknown field, spec.terraformModule.adress is an unknown field, spec.terraformVerison is an unknown field",
+		},
+		{
+			name: "unknown kind",
+			yaml: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cheese
+data:
+  foo: bar
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		u := &unstructured.Unstructured{}
+		err := yaml.Unmarshal([]byte(tc.yaml), &u.Object)
+		require.NoError(t, err, "failed to parse YAML for %s", tc.name)
+
+		err = v.Validate(u)
+		if tc.expectError == "" {
+			assert.NoError(t, err, "for %s", tc.name)
+			continue
+		}
+		require.Error(t, err, "for %s", tc.name)
+		assert.Contains(t, err.Error(), tc.expectError, "for %s", tc.name)
+		t.Logf("%s got expected error: %s", tc.name, err.Error())
+	}
+}
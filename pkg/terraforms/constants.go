@@ -8,10 +8,22 @@ const (
 
 	// LabelValueKindTest the kind label value for tests
 	LabelValueKindTest = "jx-test"
+
+	// LabelBuild the label of the build number of the pipeline which created a test resource
+	LabelBuild = "build"
+
+	// TerraformKind the kind of the Terraform Operator resource
+	TerraformKind = "Terraform"
+
+	// AnnotationOwner the annotation on the namespaces and cluster scoped resources of a test recording the
+	// namespace and name of the test resource which owns them as they cannot have an owner reference to it
+	AnnotationOwner = "jx-test.jenkins-x.io/owner"
 )
 
 var (
-	// TerraformResource the Terraform Operator resource
-	// see:
+	// TerraformResource the original tf.isaaguilar.com Terraform Operator resource. See V1Beta1 for the newer API
 	TerraformResource = schema.GroupVersionResource{Group: "tf.isaaguilar.com", Version: "v1alpha1", Resource: "terraforms"}
+
+	// TerraformKindVersion the kind of the Terraform Operator resource
+	TerraformKindVersion = TerraformResource.GroupVersion().WithKind(TerraformKind)
 )
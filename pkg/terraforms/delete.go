@@ -0,0 +1,92 @@
+package terraforms
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// DeletePath how a Terraform resource was deleted
+type DeletePath string
+
+const (
+	// DeletePathDeleted the resource was removed by the operator within the grace period
+	DeletePathDeleted DeletePath = "deleted"
+
+	// DeletePathFinalizersRemoved the resource was stuck so its finalizers were removed
+	DeletePathFinalizersRemoved DeletePath = "finalizers-removed"
+
+	// DeletePathNotFound the resource had already been removed
+	DeletePathNotFound DeletePath = "not-found"
+
+	// DefaultDeleteGracePeriod the default time to wait for the operator to destroy a Terraform resource
+	DefaultDeleteGracePeriod = 10 * time.Minute
+
+	defaultDeletePollPeriod = 5 * time.Second
+)
+
+// Deleter deletes Terraform resources giving the operator a grace period to run its destroy job
+// before removing the finalizers of any resources that are stuck
+type Deleter struct {
+	Client      dynamic.ResourceInterface
+	GracePeriod time.Duration
+	PollPeriod  time.Duration
+}
+
+// Delete deletes the resource with the given name returning how it was deleted
+func (d *Deleter) Delete(ctx context.Context, name string) (DeletePath, error) {
+	gracePeriod := d.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultDeleteGracePeriod
+	}
+	pollPeriod := d.PollPeriod
+	if pollPeriod <= 0 {
+		pollPeriod = defaultDeletePollPeriod
+	}
+
+	err := d.Client.Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return DeletePathNotFound, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete %s %s: %w", TerraformKind, name, err)
+	}
+
+	deadline := time.Now().Add(gracePeriod)
+	for {
+		_, err = d.Client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return DeletePathDeleted, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s %s: %w", TerraformKind, name, err)
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		log.Logger().Debugf("waiting for %s %s to be removed", TerraformKind, name)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(pollPeriod):
+		}
+	}
+
+	log.Logger().Warnf("%s %s was not removed within %s so removing its finalizers", TerraformKind, info(name), gracePeriod.String())
+	_, err = d.Client.Patch(ctx, name, types.MergePatchType, []byte(`{"metadata":{"finalizers":null}}`), metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return DeletePathDeleted, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to remove the finalizers of %s %s: %w", TerraformKind, name, err)
+	}
+	return DeletePathFinalizersRemoved, nil
+}
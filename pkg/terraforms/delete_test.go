@@ -0,0 +1,82 @@
+package terraforms_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms/tftests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testResource = `apiVersion: tf.isaaguilar.com/v1alpha1
+kind: Terraform
+metadata:
+  finalizers:
+  - finalizer.tf.isaaguilar.com
+  name: tf-myrepo-pr456-myctx-1
+  namespace: jx
+`
+
+func TestDeleter(t *testing.T) {
+	name := "tf-myrepo-pr456-myctx-1"
+	ctx := context.TODO()
+
+	testCases := []struct {
+		name     string
+		stuck    bool
+		missing  bool
+		expected terraforms.DeletePath
+	}{
+		{
+			name:     "deleted",
+			expected: terraforms.DeletePathDeleted,
+		},
+		{
+			name:     "stuck",
+			stuck:    true,
+			expected: terraforms.DeletePathFinalizersRemoved,
+		},
+		{
+			name:     "missing",
+			missing:  true,
+			expected: terraforms.DeletePathNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		var dynObjects []runtime.Object
+		if !tc.missing {
+			dynObjects = tftests.ParseUnstructureds(t, nil, []string{testResource})
+		}
+		fakeDynClient := tftests.NewFakeDynClient(runtime.NewScheme(), dynObjects...)
+		if tc.stuck {
+			// simulate the operator never removing its finalizer
+			fakeDynClient.PrependReactor("delete", "terraforms", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, nil
+			})
+		}
+		client := fakeDynClient.Resource(terraforms.TerraformResource).Namespace("jx")
+
+		d := &terraforms.Deleter{
+			Client:      client,
+			GracePeriod: 20 * time.Millisecond,
+			PollPeriod:  time.Millisecond,
+		}
+		path, err := d.Delete(ctx, name)
+		require.NoError(t, err, "failed to delete for %s", tc.name)
+		assert.Equal(t, tc.expected, path, "delete path for %s", tc.name)
+
+		if tc.stuck {
+			u, err := client.Get(ctx, name, metav1.GetOptions{})
+			require.NoError(t, err, "failed to get %s", name)
+			assert.Empty(t, u.GetFinalizers(), "should have removed the finalizers")
+		}
+	}
+}
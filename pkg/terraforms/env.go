@@ -0,0 +1,89 @@
+package terraforms
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// AddSecretEnv adds environment variables to the Terraform resource for the given keys of the Secret which are
+// referenced via valueFrom.secretKeyRef. Any environment variables already specified with the same names are
+// left alone
+func AddSecretEnv(version *Version, u *unstructured.Unstructured, secretName string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	// v1beta1 specifies environment variables in task options which apply to the tasks they are for so any
+	// names already specified by a task option are left alone
+	if version == V1Beta1 {
+		taskOptions, _, err := unstructured.NestedSlice(u.Object, "spec", "taskOptions")
+		if err != nil {
+			return fmt.Errorf("failed to get spec.taskOptions of %s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+		var existing []interface{}
+		for _, to := range taskOptions {
+			m, ok := to.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			taskEnv, _, _ := unstructured.NestedSlice(m, "env")
+			existing = append(existing, taskEnv...)
+		}
+		env := appendSecretEnv(existing, secretName, sorted)[len(existing):]
+		if len(env) == 0 {
+			return nil
+		}
+		taskOptions = append(taskOptions, map[string]interface{}{
+			"for": []interface{}{"*"},
+			"env": env,
+		})
+		err = unstructured.SetNestedSlice(u.Object, taskOptions, "spec", "taskOptions")
+		if err != nil {
+			return fmt.Errorf("failed to set spec.taskOptions of %s %s: %w", u.GetKind(), u.GetName(), err)
+		}
+		return nil
+	}
+
+	env, _, err := unstructured.NestedSlice(u.Object, "spec", "env")
+	if err != nil {
+		return fmt.Errorf("failed to get spec.env of %s %s: %w", u.GetKind(), u.GetName(), err)
+	}
+	env = appendSecretEnv(env, secretName, sorted)
+	err = unstructured.SetNestedSlice(u.Object, env, "spec", "env")
+	if err != nil {
+		return fmt.Errorf("failed to set spec.env of %s %s: %w", u.GetKind(), u.GetName(), err)
+	}
+	return nil
+}
+
+// appendSecretEnv appends an environment variable referencing the Secret for each key not already in env
+func appendSecretEnv(env []interface{}, secretName string, keys []string) []interface{} {
+	existing := map[string]bool{}
+	for _, e := range env {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(m, "name")
+		existing[name] = true
+	}
+	for _, k := range keys {
+		if existing[k] {
+			continue
+		}
+		env = append(env, map[string]interface{}{
+			"name": k,
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{
+					"name": secretName,
+					"key":  k,
+				},
+			},
+		})
+	}
+	return env
+}
@@ -0,0 +1,70 @@
+package terraforms_test
+
+import (
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAddSecretEnv(t *testing.T) {
+	secretName := "tf-myrepo-pr456-myctx-3-env"
+	ref := func(name string) interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"valueFrom": map[string]interface{}{
+				"secretKeyRef": map[string]interface{}{"name": secretName, "key": name},
+			},
+		}
+	}
+	existing := map[string]interface{}{"name": "TF_VAR_token", "value": "from-template"}
+	existingTaskOption := map[string]interface{}{
+		"for": []interface{}{"apply"},
+		"env": []interface{}{existing},
+	}
+
+	testCases := []struct {
+		version  *terraforms.Version
+		path     []string
+		expected []interface{}
+	}{
+		{
+			version:  terraforms.V1Alpha1,
+			path:     []string{"spec", "env"},
+			expected: []interface{}{existing, ref("TF_VAR_password")},
+		},
+		{
+			version: terraforms.V1Beta1,
+			path:    []string{"spec", "taskOptions"},
+			expected: []interface{}{
+				existingTaskOption,
+				map[string]interface{}{
+					"for": []interface{}{"*"},
+					"env": []interface{}{ref("TF_VAR_password")},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		u.SetGroupVersionKind(tc.version.KindVersion())
+		if tc.version == terraforms.V1Alpha1 {
+			err := unstructured.SetNestedSlice(u.Object, []interface{}{existing}, "spec", "env")
+			require.NoError(t, err, "failed to set spec.env")
+		} else {
+			err := unstructured.SetNestedSlice(u.Object, []interface{}{existingTaskOption}, "spec", "taskOptions")
+			require.NoError(t, err, "failed to set spec.taskOptions")
+		}
+
+		err := terraforms.AddSecretEnv(tc.version, u, secretName, []string{"TF_VAR_token", "TF_VAR_password"})
+		require.NoError(t, err, "failed to add secret env for %s", tc.version.Name)
+
+		actual, _, err := unstructured.NestedSlice(u.Object, tc.path...)
+		require.NoError(t, err, "failed to get %v for %s", tc.path, tc.version.Name)
+		assert.Equal(t, tc.expected, actual, "%v for %s", tc.path, tc.version.Name)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/jenkins-x/jx-helpers/v3/pkg/termcolor"
 	"github.com/jenkins-x/jx-logging/v3/pkg/log"
 
+	batchv1 "k8s.io/api/batch/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -17,31 +18,74 @@ var (
 	info = termcolor.ColorInfo
 )
 
-// DeleteActiveTerraformJobs deletes any non completed apply Terraform Jobs as we are about to remove the
-// Terraform resource
-func DeleteActiveTerraformJobs(ctx context.Context, kubeClient kubernetes.Interface, ns, name string) error {
+// DeleteActiveTerraformJobs deletes any non completed apply Terraform Jobs and their Pods as we are about to remove
+// the Terraform resource
+func DeleteActiveTerraformJobs(ctx context.Context, kubeClient kubernetes.Interface, version *Version, ns, name string) error {
 	jobInterface := kubeClient.BatchV1().Jobs(ns)
-	job, err := jobInterface.Get(ctx, name, metav1.GetOptions{})
-	if err != nil && apierrors.IsNotFound(err) {
-		return deleteTerraformPods(ctx, kubeClient, ns, name)
+	jobList, err := FindTerraformJobs(ctx, kubeClient, version, ns, name)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobList {
+		job := &jobList[i]
+		if jobs.IsJobFinished(job) {
+			continue
+		}
+		log.Logger().Infof("deleting terraform Job %s in namespace %s as has not finished and we are about to delete the Terraform resource", info(job.Name), ns)
+		err := jobInterface.Delete(ctx, job.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Job %s in namespace %s: %w", job.Name, ns, err)
+		}
+		log.Logger().Infof("deleted terraform Job %s in namespace %s", info(job.Name), ns)
+	}
+	return deleteTerraformPods(ctx, kubeClient, ns, version.PodSelector(name))
+}
+
+// FindTerraformJobs finds the Jobs the operator created for the given Terraform resource
+func FindTerraformJobs(ctx context.Context, kubeClient kubernetes.Interface, version *Version, ns, name string) ([]batchv1.Job, error) {
+	jobInterface := kubeClient.BatchV1().Jobs(ns)
+	selector := version.JobSelector(name)
+	if selector == "" {
+		job, err := jobInterface.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query Job %s in namespace %s: %w", name, ns, err)
+		}
+		return []batchv1.Job{*job}, nil
+	}
+	list, err := jobInterface.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
 	}
 	if err != nil {
-		return fmt.Errorf("failed to query Job %s in namespace %s: %w", name, ns, err)
+		return nil, fmt.Errorf("failed to query Jobs in namespace %s with selector %s: %w", ns, selector, err)
 	}
-	if job == nil || jobs.IsJobFinished(job) {
-		return deleteTerraformPods(ctx, kubeClient, ns, name)
+	return list.Items, nil
+}
+
+// ListTerraformJobs lists all of the apply and destroy Jobs the operator created for the given Terraform resource
+func ListTerraformJobs(ctx context.Context, kubeClient kubernetes.Interface, version *Version, ns, name string) ([]batchv1.Job, error) {
+	selector := version.JobSelector(name)
+	list, err := kubeClient.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
 	}
-	log.Logger().Infof("deleting terraform apply Job %s in namespace %s as has not finished and we are about to delete the Terraform resource", info(name), ns)
-	err = jobInterface.Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to delete Job %s in namespace %s: %w", name, ns, err)
+		return nil, fmt.Errorf("failed to list Jobs in namespace %s: %w", ns, err)
+	}
+	var answer []batchv1.Job
+	for i := range list.Items {
+		if version.IsJob(&list.Items[i], name) {
+			answer = append(answer, list.Items[i])
+		}
 	}
-	log.Logger().Infof("deleted terraform apply Job %s in namespace %s", info(name), ns)
-	return deleteTerraformPods(ctx, kubeClient, ns, name)
+	return answer, nil
 }
 
-func deleteTerraformPods(ctx context.Context, kubeClient kubernetes.Interface, ns, name string) error {
-	selector := "job-name=" + name
+func deleteTerraformPods(ctx context.Context, kubeClient kubernetes.Interface, ns, selector string) error {
 	podInterface := kubeClient.CoreV1().Pods(ns)
 	podList, err := podInterface.List(ctx, metav1.ListOptions{
 		LabelSelector: selector,
@@ -62,7 +106,7 @@ func deleteTerraformPods(ctx context.Context, kubeClient kubernetes.Interface, n
 		if err != nil {
 			return fmt.Errorf("failed to delete pod %s: %w", name, err)
 		}
-		log.Logger().Infof("deleted terraform apply Pod %s in namespace %s", info(name), ns)
+		log.Logger().Infof("deleted terraform Pod %s in namespace %s", info(name), ns)
 	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package terraforms
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/naming"
+	"github.com/jenkins-x/jx-helpers/v3/pkg/pipelinectx"
+)
+
+// PipelineLabels returns the labels create adds to the test resources of the given pipeline context
+// so they can be used as a selector. The build label is only included if a build number is specified
+func PipelineLabels(o *pipelinectx.Options) map[string]string {
+	labels := map[string]string{
+		"kind": LabelValueKindTest,
+	}
+	for k, v := range o.Labels {
+		labels[k] = v
+	}
+	if o.Context != "" {
+		labels["context"] = naming.ToValidName(o.Context)
+	}
+	if o.RepoName != "" {
+		labels["repo"] = naming.ToValidName(o.RepoName)
+	}
+	if o.RepoOwner != "" {
+		labels["owner"] = naming.ToValidName(o.RepoOwner)
+	}
+	if o.PullRequestNumber > 0 {
+		labels["pr"] = naming.ToValidName("PR-" + strconv.Itoa(o.PullRequestNumber))
+	}
+	if o.BuildNumber != "" {
+		labels[LabelBuild] = naming.ToValidValue(o.BuildNumber)
+	}
+	return labels
+}
+
+// Owner the test resource recorded in the owner annotation of a resource which cannot have an owner reference to it
+type Owner struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+// String returns the namespace and name of the owner
+func (o *Owner) String() string {
+	if o.Namespace == "" {
+		return o.Name
+	}
+	return o.Namespace + "/" + o.Name
+}
+
+// OwnerAnnotation returns the value of the owner annotation for the given owner: apiVersion/kind/namespace/name
+func OwnerAnnotation(o *Owner) string {
+	return strings.Join([]string{o.APIVersion, o.Kind, o.Namespace, o.Name}, "/")
+}
+
+// ParseOwnerAnnotation returns the test resource in the owner annotation or nil if there is no owner annotation.
+// The kind and apiVersion are empty if the annotation only has a namespace and name
+func ParseOwnerAnnotation(annotations map[string]string) *Owner {
+	value := strings.TrimSpace(annotations[AnnotationOwner])
+	if value == "" {
+		return nil
+	}
+	paths := strings.Split(value, "/")
+	n := len(paths)
+	switch {
+	case n == 1:
+		return &Owner{Name: value}
+	case n < 4:
+		return &Owner{Namespace: paths[n-2], Name: paths[n-1]}
+	default:
+		return &Owner{
+			APIVersion: strings.Join(paths[:n-3], "/"),
+			Kind:       paths[n-3],
+			Namespace:  paths[n-2],
+			Name:       paths[n-1],
+		}
+	}
+}
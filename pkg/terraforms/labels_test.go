@@ -0,0 +1,29 @@
+package terraforms_test
+
+import (
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnerAnnotation(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected *terraforms.Owner
+	}{
+		{value: ""},
+		{value: "tf-1", expected: &terraforms.Owner{Name: "tf-1"}},
+		{value: "jx/tf-1", expected: &terraforms.Owner{Namespace: "jx", Name: "tf-1"}},
+		{value: "v1/ConfigMap/jx/vars", expected: &terraforms.Owner{APIVersion: "v1", Kind: "ConfigMap", Namespace: "jx", Name: "vars"}},
+		{value: "tf.isaaguilar.com/v1alpha1/Terraform/jx/tf-1", expected: &terraforms.Owner{APIVersion: "tf.isaaguilar.com/v1alpha1", Kind: "Terraform", Namespace: "jx", Name: "tf-1"}},
+		{value: "example.com/v1/Cheese//brie", expected: &terraforms.Owner{APIVersion: "example.com/v1", Kind: "Cheese", Name: "brie"}},
+	}
+	for _, tc := range testCases {
+		owner := terraforms.ParseOwnerAnnotation(map[string]string{terraforms.AnnotationOwner: tc.value})
+		assert.Equal(t, tc.expected, owner, "owner for %q", tc.value)
+		if owner != nil && owner.Kind != "" {
+			assert.Equal(t, tc.value, terraforms.OwnerAnnotation(owner), "annotation for %q", tc.value)
+		}
+	}
+}
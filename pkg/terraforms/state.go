@@ -0,0 +1,52 @@
+package terraforms
+
+import (
+	"strings"
+
+	"github.com/jenkins-x/jx-helpers/v3/pkg/kube/jobs"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// StateSelector the selector of the state Secrets and Leases of the Terraform kubernetes backend
+	StateSelector = "tfstate=true"
+
+	// LabelStateSecretSuffix the label the Terraform kubernetes backend adds to its state Secrets and Leases
+	LabelStateSecretSuffix = "tfstateSecretSuffix"
+
+	// LabelStateWorkspace the label of the Terraform workspace of the state Secrets and Leases
+	LabelStateWorkspace = "tfstateWorkspace"
+
+	statePrefix      = "tfstate-"
+	stateLockPrefix  = "lock-"
+	stateSuffix      = "-state"
+	defaultWorkspace = "default"
+)
+
+// StateOwnerName returns the name of the Terraform resource which owns the given state Secret or Lease
+// using its secret suffix label or its name or returns an empty string if it cannot be determined
+func StateOwnerName(obj metav1.Object) string {
+	labels := obj.GetLabels()
+	suffix := labels[LabelStateSecretSuffix]
+	if suffix == "" {
+		workspace := labels[LabelStateWorkspace]
+		if workspace == "" {
+			workspace = defaultWorkspace
+		}
+		name := strings.TrimPrefix(obj.GetName(), stateLockPrefix)
+		prefix := statePrefix + workspace + "-"
+		if !strings.HasPrefix(name, prefix) {
+			return ""
+		}
+		suffix = strings.TrimPrefix(name, prefix)
+	}
+	return strings.TrimSuffix(suffix, stateSuffix)
+}
+
+// IsActiveTerraformJob returns true if the job is an apply or destroy Job of the given Terraform resource
+// which has not finished yet
+func IsActiveTerraformJob(version *Version, job *batchv1.Job, name string) bool {
+	return version.IsJob(job, name) && !jobs.IsJobFinished(job)
+}
@@ -0,0 +1,88 @@
+package terraforms_test
+
+import (
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/stretchr/testify/assert"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStateOwnerName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+	}{
+		{
+			name:     "tfstate-default-tf-myrepo-pr456-myctx-1-state",
+			expected: "tf-myrepo-pr456-myctx-1",
+		},
+		{
+			name:     "lock-tfstate-default-tf-myrepo-pr456-myctx-1-state",
+			expected: "tf-myrepo-pr456-myctx-1",
+		},
+		{
+			name:     "something-else",
+			labels:   map[string]string{terraforms.LabelStateSecretSuffix: "tf-abc-state"},
+			expected: "tf-abc",
+		},
+		{
+			name:     "tfstate-dev-tf-abc-state",
+			labels:   map[string]string{terraforms.LabelStateWorkspace: "dev"},
+			expected: "tf-abc",
+		},
+		{
+			name:     "something-else",
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: tc.name, Labels: tc.labels},
+		}
+		got := terraforms.StateOwnerName(secret)
+		assert.Equal(t, tc.expected, got, "owner of %s", tc.name)
+	}
+}
+
+func TestIsActiveTerraformJob(t *testing.T) {
+	name := "tf-abc"
+	finished := batchv1.JobStatus{
+		Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+		},
+	}
+
+	testCases := []struct {
+		job      string
+		owner    string
+		status   batchv1.JobStatus
+		expected bool
+	}{
+		{job: "tf-abc", expected: true},
+		{job: "tf-abc-destroy", expected: true},
+		{job: "tf-abc-destroy", status: finished, expected: false},
+		{job: "tf-abcdef", expected: false},
+		{job: "tf-abc-gke", expected: false},
+		{job: "tf-abc-gke-destroy", expected: false},
+		{job: "tf-abc-gke", owner: "tf-abc-gke", expected: false},
+		{job: "tf-abc-apply", owner: "tf-abc", expected: true},
+	}
+
+	for _, tc := range testCases {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: tc.job},
+			Status:     tc.status,
+		}
+		if tc.owner != "" {
+			job.OwnerReferences = []metav1.OwnerReference{{Kind: terraforms.TerraformKind, Name: tc.owner}}
+		}
+		got := terraforms.IsActiveTerraformJob(terraforms.V1Alpha1, job, name)
+		assert.Equal(t, tc.expected, got, "active for job %s", tc.job)
+	}
+}
@@ -3,14 +3,32 @@ package tftests
 import (
 	"testing"
 
+	"github.com/jenkins-x-plugins/jx-test/pkg/dynkube"
 	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/yaml"
 
 	"github.com/stretchr/testify/require"
 	dynfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	// ConfigMapResource the ConfigMap resource used in multi document templates
+	ConfigMapResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	// SecretResource the Secret resource used in multi document templates
+	SecretResource = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	// ClusterRoleResource the cluster scoped ClusterRole resource
+	ClusterRoleResource = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
 )
 
 // ParseUnstructureds parses the resources
@@ -32,7 +50,74 @@ func ParseUnstructureds(t *testing.T, fn func(idx int, u *unstructured.Unstructu
 // NewFakeDynClient creates a new dynamic client with the external secrets
 func NewFakeDynClient(scheme *runtime.Scheme, dynObjects ...runtime.Object) *dynfake.FakeDynamicClient {
 	gvrToListKind := map[schema.GroupVersionResource]string{
-		terraforms.TerraformResource: "TerraformList",
+		ConfigMapResource:   "ConfigMapList",
+		SecretResource:      "SecretList",
+		ClusterRoleResource: "ClusterRoleList",
+	}
+	for _, v := range terraforms.Versions {
+		gvrToListKind[v.Resource] = "TerraformList"
 	}
 	return dynfake.NewSimpleDynamicClientWithCustomListKinds(scheme, gvrToListKind, dynObjects...)
 }
+
+// NewFakeRESTMapper creates a static RESTMapper for the Terraform resources of each version, ConfigMap and Secret resources and any additional mappings
+func NewFakeRESTMapper(mappings ...dynkube.StaticMapping) meta.RESTMapper {
+	for _, v := range terraforms.Versions {
+		mappings = append(mappings, dynkube.StaticMapping{
+			GroupVersionKind: v.KindVersion(),
+			Resource:         v.Resource.Resource,
+		})
+	}
+	mappings = append(mappings,
+		dynkube.StaticMapping{GroupVersionKind: ConfigMapResource.GroupVersion().WithKind("ConfigMap")},
+		dynkube.StaticMapping{GroupVersionKind: SecretResource.GroupVersion().WithKind("Secret")},
+	)
+	return dynkube.NewStaticRESTMapper(mappings...)
+}
+
+// AddFakeApplyReactor makes server-side apply on the fake dynamic client create the object if it does not exist or
+// replace its labels, annotations and spec, incrementing its generation if the spec changed. The optional function
+// is invoked with each object which is created or whose spec changed, like an operator reconciling it
+func AddFakeApplyReactor(client *dynfake.FakeDynamicClient, fn func(gvr schema.GroupVersionResource, u *unstructured.Unstructured)) {
+	tracker := client.Tracker()
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction, ok := action.(clienttesting.PatchAction)
+		if !ok || patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		gvr := patchAction.GetResource()
+		ns := patchAction.GetNamespace()
+		applied := &unstructured.Unstructured{}
+		err := yaml.Unmarshal(patchAction.GetPatch(), &applied.Object)
+		if err != nil {
+			return true, nil, err
+		}
+
+		existing, err := tracker.Get(gvr, ns, patchAction.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			applied.SetGeneration(1)
+			err = tracker.Create(gvr, applied, ns)
+			if err == nil && fn != nil {
+				fn(gvr, applied)
+			}
+			return true, applied, err
+		}
+		if err != nil {
+			return true, nil, err
+		}
+
+		u := existing.(*unstructured.Unstructured).DeepCopy()
+		u.SetLabels(applied.GetLabels())
+		u.SetAnnotations(applied.GetAnnotations())
+		changed := !equality.Semantic.DeepEqual(u.Object["spec"], applied.Object["spec"])
+		if changed {
+			u.Object["spec"] = applied.Object["spec"]
+			u.SetGeneration(u.GetGeneration() + 1)
+		}
+		err = tracker.Update(gvr, u, ns)
+		if err == nil && changed && fn != nil {
+			fn(gvr, u)
+		}
+		return true, u, err
+	})
+}
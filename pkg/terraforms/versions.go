@@ -0,0 +1,132 @@
+package terraforms
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jenkins-x/jx-logging/v3/pkg/log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// Version a version of the Terraform Operator API along with how the operator names its Jobs and Pods
+type Version struct {
+	// Name the name of the version used by the --tf-api-version flag
+	Name string
+
+	// Resource the Terraform resource of this version
+	Resource schema.GroupVersionResource
+
+	// ResourceLabel the label the operator adds to its Jobs and Pods with the name of the Terraform resource.
+	// If empty the Jobs are named after the Terraform resource
+	ResourceLabel string
+}
+
+// destroyJobSuffix the suffix of the name of the destroy Job the v1alpha1 operator creates for a Terraform resource
+const destroyJobSuffix = "-destroy"
+
+var (
+	// V1Alpha1 the original tf.isaaguilar.com Terraform Operator API which names its apply Job after the resource
+	V1Alpha1 = &Version{
+		Name:     "v1alpha1",
+		Resource: TerraformResource,
+	}
+
+	// V1Beta1 the tf.galleybytes.com Terraform Operator API which labels its Jobs and Pods with the resource name
+	V1Beta1 = &Version{
+		Name:          "v1beta1",
+		Resource:      schema.GroupVersionResource{Group: "tf.galleybytes.com", Version: "v1beta1", Resource: "terraforms"},
+		ResourceLabel: "terraforms.tf.galleybytes.com/resourceName",
+	}
+
+	// Versions the supported versions in order of preference
+	Versions = []*Version{V1Beta1, V1Alpha1}
+)
+
+// KindVersion returns the kind of the Terraform resource of this version
+func (v *Version) KindVersion() schema.GroupVersionKind {
+	return v.Resource.GroupVersion().WithKind(TerraformKind)
+}
+
+// JobSelector returns the label selector of the Jobs of the given Terraform resource or an empty string
+// if the Jobs cannot be selected by label
+func (v *Version) JobSelector(name string) string {
+	if v.ResourceLabel == "" {
+		return ""
+	}
+	return v.ResourceLabel + "=" + name
+}
+
+// PodSelector returns the label selector of the Pods of the given Terraform resource
+func (v *Version) PodSelector(name string) string {
+	if v.ResourceLabel == "" {
+		return "job-name=" + name
+	}
+	return v.ResourceLabel + "=" + name
+}
+
+// IsJob returns true if the job was created by the operator for the given Terraform resource. Without a resource
+// label the Job is matched by its owner reference if it has one, otherwise by the names of the apply and destroy Jobs
+// so that the Jobs of a resource whose name starts with the given name are not matched
+func (v *Version) IsJob(job *batchv1.Job, name string) bool {
+	if v.ResourceLabel != "" {
+		return job.Labels[v.ResourceLabel] == name
+	}
+	for _, ref := range job.OwnerReferences {
+		if ref.Kind == TerraformKind {
+			return ref.Name == name
+		}
+	}
+	return job.Name == name || job.Name == name+destroyJobSuffix
+}
+
+// FindVersion returns the version with the given name
+func FindVersion(name string) (*Version, error) {
+	var names []string
+	for _, v := range Versions {
+		if v.Name == name {
+			return v, nil
+		}
+		names = append(names, v.Name)
+	}
+	return nil, fmt.Errorf("unknown Terraform API version %s. Supported versions are %s", name, strings.Join(names, ", "))
+}
+
+// ResolveVersion returns the version with the given name or detects the version via discovery if no name is given
+func ResolveVersion(name string, discoveryClient discovery.DiscoveryInterface) (*Version, error) {
+	if name != "" {
+		return FindVersion(name)
+	}
+	return DetectVersion(discoveryClient), nil
+}
+
+// VersionForResource returns the version of the given resource or nil if it is not a Terraform resource
+func VersionForResource(gvr schema.GroupVersionResource) *Version {
+	for _, v := range Versions {
+		if v.Resource == gvr {
+			return v
+		}
+	}
+	return nil
+}
+
+// DetectVersion detects the version of the Terraform Operator installed in the cluster via discovery.
+// Defaults to V1Alpha1 if no Terraform resource could be found
+func DetectVersion(discoveryClient discovery.DiscoveryInterface) *Version {
+	for _, v := range Versions {
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(v.Resource.GroupVersion().String())
+		if err != nil || resources == nil {
+			continue
+		}
+		for i := range resources.APIResources {
+			if resources.APIResources[i].Name == v.Resource.Resource {
+				log.Logger().Debugf("detected the Terraform API version %s", v.Resource.GroupVersion().String())
+				return v
+			}
+		}
+	}
+	log.Logger().Debugf("could not detect the Terraform API version so using %s", V1Alpha1.Resource.GroupVersion().String())
+	return V1Alpha1
+}
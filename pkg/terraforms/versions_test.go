@@ -0,0 +1,102 @@
+package terraforms_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jenkins-x-plugins/jx-test/pkg/terraforms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindVersion(t *testing.T) {
+	v, err := terraforms.FindVersion("v1beta1")
+	require.NoError(t, err, "failed to find v1beta1")
+	assert.Equal(t, "tf.galleybytes.com", v.Resource.Group, "group of v1beta1")
+
+	_, err = terraforms.FindVersion("v2")
+	require.Error(t, err, "should fail to find an unknown version")
+}
+
+func TestDetectVersion(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	assert.Equal(t, terraforms.V1Alpha1, terraforms.DetectVersion(kubeClient.Discovery()), "default version")
+
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "tf.galleybytes.com/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "terraforms", Kind: "Terraform", Namespaced: true}},
+		},
+	}
+	assert.Equal(t, terraforms.V1Beta1, terraforms.DetectVersion(kubeClient.Discovery()), "detected version")
+}
+
+func TestDeleteActiveTerraformJobs(t *testing.T) {
+	ns := "jx"
+	name := "tf-abc"
+	ctx := context.TODO()
+
+	testCases := []struct {
+		version   *terraforms.Version
+		objects   []metav1.ObjectMeta
+		remaining []string
+	}{
+		{
+			version: terraforms.V1Alpha1,
+			objects: []metav1.ObjectMeta{
+				{Name: "tf-abc", Namespace: ns},
+				{Name: "tf-other", Namespace: ns},
+			},
+			remaining: []string{"tf-other"},
+		},
+		{
+			version: terraforms.V1Beta1,
+			objects: []metav1.ObjectMeta{
+				{Name: "tf-abc-1-apply", Namespace: ns, Labels: map[string]string{terraforms.V1Beta1.ResourceLabel: name}},
+				{Name: "tf-abc", Namespace: ns},
+				{Name: "tf-other-1-apply", Namespace: ns, Labels: map[string]string{terraforms.V1Beta1.ResourceLabel: "tf-other"}},
+			},
+			remaining: []string{"tf-abc", "tf-other-1-apply"},
+		},
+	}
+
+	for _, tc := range testCases {
+		kubeClient := fake.NewSimpleClientset()
+		for _, m := range tc.objects {
+			_, err := kubeClient.BatchV1().Jobs(ns).Create(ctx, &batchv1.Job{ObjectMeta: m}, metav1.CreateOptions{})
+			require.NoError(t, err, "failed to create Job %s", m.Name)
+
+			podMeta := m
+			if tc.version.ResourceLabel == "" {
+				podMeta.Labels = map[string]string{"job-name": m.Name}
+			}
+			_, err = kubeClient.CoreV1().Pods(ns).Create(ctx, &corev1.Pod{ObjectMeta: podMeta}, metav1.CreateOptions{})
+			require.NoError(t, err, "failed to create Pod %s", m.Name)
+		}
+
+		err := terraforms.DeleteActiveTerraformJobs(ctx, kubeClient, tc.version, ns, name)
+		require.NoError(t, err, "failed to delete jobs for %s", tc.version.Name)
+
+		jobList, err := kubeClient.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err, "failed to list Jobs")
+		var jobNames []string
+		for i := range jobList.Items {
+			jobNames = append(jobNames, jobList.Items[i].Name)
+		}
+		assert.ElementsMatch(t, tc.remaining, jobNames, "remaining Jobs for %s", tc.version.Name)
+
+		podList, err := kubeClient.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err, "failed to list Pods")
+		var podNames []string
+		for i := range podList.Items {
+			podNames = append(podNames, podList.Items[i].Name)
+		}
+		assert.ElementsMatch(t, tc.remaining, podNames, "remaining Pods for %s", tc.version.Name)
+	}
+}